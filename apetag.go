@@ -0,0 +1,200 @@
+package musiclib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/dhowden/tag"
+)
+
+const (
+	apeFooterSize = 32
+	id3v1Size     = 128
+	// apeMaxTagSize guards against allocating huge buffers for corrupt tags.
+	apeMaxTagSize = 16 << 20
+)
+
+// readAPETags reads an APEv2 tag from the end of a file as used by WavPack,
+// Monkey's Audio and Musepack.
+func readAPETags(r io.ReadSeeker) (tag.Metadata, error) {
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	footer, footerEnd, err := findAPEFooter(r, end)
+	if err != nil {
+		return nil, err
+	}
+
+	tagSize := int64(binary.LittleEndian.Uint32(footer[12:16]))
+	itemCount := int(binary.LittleEndian.Uint32(footer[16:20]))
+	if tagSize < apeFooterSize || tagSize > apeMaxTagSize || tagSize > footerEnd {
+		return nil, errors.New("invalid APE tag size")
+	}
+
+	// the tag size covers the items and footer but not the optional header
+	if _, err := r.Seek(footerEnd-tagSize, io.SeekStart); err != nil {
+		return nil, err
+	}
+	items := make([]byte, tagSize-apeFooterSize)
+	if _, err := io.ReadFull(r, items); err != nil {
+		return nil, err
+	}
+
+	m := &apeMetadata{
+		items: make(map[string]interface{}),
+	}
+	for i := 0; i < itemCount && len(items) >= 8; i++ {
+		valueSize := int(binary.LittleEndian.Uint32(items[0:4]))
+		flags := binary.LittleEndian.Uint32(items[4:8])
+		items = items[8:]
+
+		keyEnd := bytes.IndexByte(items, 0)
+		if keyEnd < 0 || valueSize < 0 || keyEnd+1+valueSize > len(items) {
+			return nil, errors.New("invalid APE tag item")
+		}
+		key := strings.ToLower(string(items[:keyEnd]))
+		value := items[keyEnd+1 : keyEnd+1+valueSize]
+		items = items[keyEnd+1+valueSize:]
+
+		// bits 1-2 hold the item type, 1 is binary
+		if (flags>>1)&3 == 1 {
+			m.items[key] = append([]byte(nil), value...)
+		} else {
+			m.items[key] = string(value)
+		}
+	}
+
+	return m, nil
+}
+
+// findAPEFooter returns the APE footer and the offset just past it. The tag
+// may be followed by an ID3v1 tag.
+func findAPEFooter(r io.ReadSeeker, end int64) ([]byte, int64, error) {
+	footer := make([]byte, apeFooterSize)
+	for _, footerEnd := range []int64{end, end - id3v1Size} {
+		if footerEnd < apeFooterSize {
+			continue
+		}
+		if _, err := r.Seek(footerEnd-apeFooterSize, io.SeekStart); err != nil {
+			return nil, 0, err
+		}
+		if _, err := io.ReadFull(r, footer); err != nil {
+			return nil, 0, err
+		}
+		if string(footer[:8]) == "APETAGEX" {
+			return footer, footerEnd, nil
+		}
+	}
+	return nil, 0, tag.ErrNoTagsFound
+}
+
+type apeMetadata struct {
+	items map[string]interface{}
+}
+
+func (m *apeMetadata) text(key string) string {
+	v, _ := m.items[key].(string)
+	// multiple values are separated by null bytes
+	if i := strings.IndexByte(v, 0); i >= 0 {
+		v = v[:i]
+	}
+	return v
+}
+
+func (m *apeMetadata) Format() tag.Format {
+	return tag.Format("APEv2")
+}
+
+func (m *apeMetadata) FileType() tag.FileType {
+	return tag.UnknownFileType
+}
+
+func (m *apeMetadata) Title() string {
+	return m.text("title")
+}
+
+func (m *apeMetadata) Album() string {
+	return m.text("album")
+}
+
+func (m *apeMetadata) Artist() string {
+	return m.text("artist")
+}
+
+func (m *apeMetadata) AlbumArtist() string {
+	return m.text("album artist")
+}
+
+func (m *apeMetadata) Composer() string {
+	return m.text("composer")
+}
+
+func (m *apeMetadata) Year() int {
+	year := m.text("year")
+	if len(year) > 4 {
+		year = year[:4]
+	}
+	y, _ := strconv.Atoi(year)
+	return y
+}
+
+func (m *apeMetadata) Genre() string {
+	return m.text("genre")
+}
+
+func (m *apeMetadata) Track() (int, int) {
+	return parseNumberOfTotal(m.text("track"))
+}
+
+func (m *apeMetadata) Disc() (int, int) {
+	return parseNumberOfTotal(m.text("disc"))
+}
+
+func (m *apeMetadata) Picture() *tag.Picture {
+	data, ok := m.items["cover art (front)"].([]byte)
+	if !ok {
+		return nil
+	}
+
+	// binary cover art starts with the null terminated file name
+	nameEnd := bytes.IndexByte(data, 0)
+	if nameEnd < 0 {
+		return nil
+	}
+	ext := strings.TrimPrefix(strings.ToLower(path.Ext(string(data[:nameEnd]))), ".")
+
+	return &tag.Picture{
+		Ext:      ext,
+		MIMEType: fmt.Sprintf("image/%s", strings.Replace(ext, "jpg", "jpeg", 1)),
+		Type:     "Cover (front)",
+		Data:     data[nameEnd+1:],
+	}
+}
+
+func (m *apeMetadata) Lyrics() string {
+	return m.text("lyrics")
+}
+
+func (m *apeMetadata) Comment() string {
+	return m.text("comment")
+}
+
+func (m *apeMetadata) Raw() map[string]interface{} {
+	return m.items
+}
+
+// parseNumberOfTotal parses values like "3" or "3/12".
+func parseNumberOfTotal(s string) (int, int) {
+	number, total, _ := strings.Cut(s, "/")
+	n, _ := strconv.Atoi(strings.TrimSpace(number))
+	t, _ := strconv.Atoi(strings.TrimSpace(total))
+	return n, t
+}
@@ -0,0 +1,220 @@
+package musiclib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/dhowden/tag"
+)
+
+type apeItem struct {
+	key    string
+	value  []byte
+	binary bool
+}
+
+func le32(v uint32) []byte {
+	return binary.LittleEndian.AppendUint32(nil, v)
+}
+
+// apeTag returns an APEv2 tag of items without a header. itemCount overrides
+// the number of items in the footer if not negative.
+func apeTag(itemCount int, items ...apeItem) []byte {
+	var body bytes.Buffer
+	for _, item := range items {
+		var flags uint32
+		if item.binary {
+			flags = 1 << 1
+		}
+		body.Write(le32(uint32(len(item.value))))
+		body.Write(le32(flags))
+		body.WriteString(item.key)
+		body.WriteByte(0)
+		body.Write(item.value)
+	}
+	if itemCount < 0 {
+		itemCount = len(items)
+	}
+	footer := concat([]byte("APETAGEX"), le32(2000), le32(uint32(body.Len()+apeFooterSize)),
+		le32(uint32(itemCount)), le32(0), make([]byte, 8))
+	return append(body.Bytes(), footer...)
+}
+
+func TestReadAPETags(t *testing.T) {
+	audio := []byte("wvpk audio data")
+	items := []apeItem{
+		{key: "Title", value: []byte("Song")},
+		{key: "Artist", value: []byte("Artist\x00Other Artist")},
+		{key: "Album", value: []byte("Album")},
+		{key: "Track", value: []byte("3/12")},
+		{key: "Year", value: []byte("1999-05-01")},
+		{key: "Cover Art (Front)", value: []byte("cover.jpg\x00jpegdata"), binary: true},
+	}
+
+	t.Run("tags", func(t *testing.T) {
+		for _, suffix := range [][]byte{nil, append([]byte("TAG"), make([]byte, id3v1Size-3)...)} {
+			data := concat(audio, apeTag(-1, items...), suffix)
+			m, err := readAPETags(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("readAPETags: %v", err)
+			}
+			track, total := m.Track()
+			if m.Title() != "Song" || m.Artist() != "Artist" || m.Album() != "Album" ||
+				track != 3 || total != 12 || m.Year() != 1999 {
+				t.Errorf("got %q %q %q %d/%d %d", m.Title(), m.Artist(), m.Album(), track, total, m.Year())
+			}
+			pic := m.Picture()
+			if pic == nil || pic.MIMEType != "image/jpeg" || string(pic.Data) != "jpegdata" {
+				t.Errorf("got picture %+v", pic)
+			}
+		}
+	})
+
+	tests := []struct {
+		name  string
+		data  []byte
+		noTag bool
+	}{
+		{name: "empty", data: nil, noTag: true},
+		{name: "no tag", data: audio, noTag: true},
+		{name: "shorter than footer", data: []byte("APETAGEX"), noTag: true},
+		{
+			name: "size larger than file",
+			data: concat([]byte("APETAGEX"), le32(2000), le32(1000), le32(0), le32(0), make([]byte, 8)),
+		},
+		{
+			name: "size smaller than footer",
+			data: concat(audio, []byte("APETAGEX"), le32(2000), le32(8), le32(0), le32(0), make([]byte, 8)),
+		},
+		{
+			name: "value past end of items",
+			data: concat(audio, le32(100), le32(0), []byte("Title\x00Song"),
+				[]byte("APETAGEX"), le32(2000), le32(uint32(8+10+apeFooterSize)), le32(1), le32(0), make([]byte, 8)),
+		},
+		{
+			name: "key without terminator",
+			data: concat(audio, le32(0), le32(0), []byte("Title"),
+				[]byte("APETAGEX"), le32(2000), le32(uint32(8+5+apeFooterSize)), le32(1), le32(0), make([]byte, 8)),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			m, err := readAPETags(bytes.NewReader(tc.data))
+			if err == nil {
+				t.Fatalf("got %+v, want error", m)
+			}
+			if got := errors.Is(err, tag.ErrNoTagsFound); got != tc.noTag {
+				t.Errorf("got error %v, want no tags %v", err, tc.noTag)
+			}
+		})
+	}
+
+	t.Run("fewer items than counted", func(t *testing.T) {
+		data := concat(audio, apeTag(5, items[0]))
+		m, err := readAPETags(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("readAPETags: %v", err)
+		}
+		if m.Title() != "Song" {
+			t.Errorf("got title %q", m.Title())
+		}
+	})
+}
+
+// iffChunk returns a chunk with a 4 byte id, a size of sizeLen bytes in
+// order and data padded to an even length.
+func iffChunk(id string, sizeLen int, order binary.ByteOrder, data []byte) []byte {
+	size := make([]byte, sizeLen)
+	if sizeLen == 8 {
+		order.PutUint64(size, uint64(len(data)))
+	} else {
+		order.PutUint32(size, uint32(len(data)))
+	}
+	chunk := concat([]byte(id), size, data)
+	if len(data)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+func TestReadChunkedID3(t *testing.T) {
+	id3 := id3v23(map[string]string{"TIT2": "Song", "TPE1": "Artist", "TALB": "Album"}, nil)
+	riffHeader := concat([]byte("RIFF"), le32(0), []byte("WAVE"))
+	aiffHeader := concat([]byte("FORM"), be32(0), []byte("AIFF"))
+	dsdiffHeader := concat([]byte("FRM8"), make([]byte, 8), []byte("DSD "))
+	huge := concat([]byte("DATA"), bytes.Repeat([]byte{0xff}, 8))
+
+	tests := []struct {
+		name    string
+		read    TagReader
+		data    []byte
+		noTag   bool
+		wantErr bool
+	}{
+		{
+			name: "wav",
+			read: readRIFFTags,
+			data: concat(riffHeader, iffChunk("fmt ", 4, binary.LittleEndian, make([]byte, 16)),
+				iffChunk("id3 ", 4, binary.LittleEndian, id3)),
+		},
+		{
+			name: "aiff after odd chunk",
+			read: readAIFFTags,
+			data: concat(aiffHeader, iffChunk("COMM", 4, binary.BigEndian, make([]byte, 19)),
+				iffChunk("ID3 ", 4, binary.BigEndian, id3)),
+		},
+		{
+			name: "dsdiff",
+			read: readDSDIFFTags,
+			data: concat(dsdiffHeader, iffChunk("PROP", 8, binary.BigEndian, make([]byte, 4)),
+				iffChunk("ID3 ", 8, binary.BigEndian, id3)),
+		},
+		{
+			name:  "no id3 chunk",
+			read:  readRIFFTags,
+			data:  concat(riffHeader, iffChunk("fmt ", 4, binary.LittleEndian, make([]byte, 16))),
+			noTag: true,
+		},
+		{
+			name:  "truncated chunk header",
+			read:  readAIFFTags,
+			data:  concat(aiffHeader, []byte("COM")),
+			noTag: true,
+		},
+		{
+			name:  "header only",
+			read:  readRIFFTags,
+			data:  riffHeader[:6],
+			noTag: true,
+		},
+		{
+			name:    "negative chunk size",
+			read:    readDSDIFFTags,
+			data:    concat(dsdiffHeader, huge),
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			m, err := tc.read(bytes.NewReader(tc.data))
+			switch {
+			case tc.noTag:
+				if !errors.Is(err, tag.ErrNoTagsFound) {
+					t.Errorf("got error %v, want no tags", err)
+				}
+			case tc.wantErr:
+				if err == nil {
+					t.Errorf("got %+v, want error", m)
+				}
+			case err != nil:
+				t.Fatalf("read: %v", err)
+			case m.Title() != "Song" || m.Artist() != "Artist" || m.Album() != "Album":
+				t.Errorf("got %q %q %q", m.Title(), m.Artist(), m.Album())
+			}
+		})
+	}
+}
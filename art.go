@@ -0,0 +1,95 @@
+package musiclib
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/dhowden/tag"
+)
+
+// ErrArtNotFound is returned when a URI doesn't identify art in the library.
+var ErrArtNotFound = errors.New("art not found")
+
+// Art is an image embedded in the tags of a media file.
+type Art struct {
+	MIMEType string
+	Data     []byte
+}
+
+// embeddedArtURI identifies a picture by a hash of its content so files
+// sharing the same picture share a URI.
+func embeddedArtURI(pic *tag.Picture) string {
+	if pic == nil || len(pic.Data) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256(pic.Data)
+	return encodeCustomURI("art", hex.EncodeToString(sum[:16]))
+}
+
+// fileArtURI returns the art of a file in dir: the directory's own image,
+// then art embedded in the file, then an image inherited from a parent
+// directory.
+func fileArtURI(dir *PathMeta, file *PathMeta) string {
+	if dir != nil && !dir.ImageInherited && dir.ImagePath != "" {
+		return encodeFileURI(dir.ImagePath)
+	}
+	if file.Metadata != nil {
+		if uri := file.Metadata.AlbumArtURI(); uri != "" {
+			return uri
+		}
+	}
+	return inheritedArtURI(dir)
+}
+
+// inheritedArtURI returns the image dir inherited from a parent directory,
+// if any.
+func inheritedArtURI(dir *PathMeta) string {
+	if dir == nil || !dir.ImageInherited {
+		return ""
+	}
+	return encodeFileURI(dir.ImagePath)
+}
+
+// Art returns the embedded art identified by an art URI from the library.
+func (l *IndexedLibrary) Art(ctx context.Context, uri string) (*Art, error) {
+	filePath, ok := l.artSources[uri]
+	if !ok {
+		return nil, ErrArtNotFound
+	}
+
+	f, err := l.fsys.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r, err := readSeeker(f)
+	if err != nil {
+		return nil, err
+	}
+
+	readTags := tag.ReadFrom
+	if format := formatForExt(strings.ToLower(path.Ext(filePath))); format != nil {
+		readTags = format.ReadTags
+	}
+	tagMeta, err := readTags(r)
+	if err != nil {
+		return nil, fmt.Errorf("read tags from %s: %v", filePath, err)
+	}
+
+	// the file may have changed since it was scanned
+	pic := tagMeta.Picture()
+	if embeddedArtURI(pic) != uri {
+		return nil, ErrArtNotFound
+	}
+
+	return &Art{
+		MIMEType: pic.MIMEType,
+		Data:     pic.Data,
+	}, nil
+}
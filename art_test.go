@@ -0,0 +1,109 @@
+package musiclib
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"testing"
+	"testing/fstest"
+
+	"github.com/dhowden/tag"
+)
+
+// id3v23 returns an ID3v2.3 tag holding text frames and, if picture is set,
+// an APIC frame with it followed by an MPEG frame.
+func id3v23(frames map[string]string, picture []byte) []byte {
+	var body bytes.Buffer
+	writeFrame := func(id string, data []byte) {
+		body.WriteString(id)
+		binary.Write(&body, binary.BigEndian, uint32(len(data)))
+		body.Write([]byte{0, 0})
+		body.Write(data)
+	}
+	for id, text := range frames {
+		writeFrame(id, append([]byte{0}, text...))
+	}
+	if picture != nil {
+		data := append([]byte("\x00image/png\x00\x03\x00"), picture...)
+		writeFrame("APIC", data)
+	}
+
+	size := body.Len()
+	header := []byte{'I', 'D', '3', 3, 0, 0,
+		byte(size >> 21 & 0x7f), byte(size >> 14 & 0x7f), byte(size >> 7 & 0x7f), byte(size & 0x7f)}
+	return append(append(header, body.Bytes()...), mp3Frame...)
+}
+
+func TestArtPrecedence(t *testing.T) {
+	picture := []byte("\x89PNG\r\n\x1a\nembedded")
+	embedded := embeddedArtURI(&tag.Picture{Data: picture})
+	song := func(album string, picture []byte) *fstest.MapFile {
+		return &fstest.MapFile{Data: id3v23(map[string]string{
+			"TIT2": "Song",
+			"TPE1": "Artist",
+			"TALB": album,
+		}, picture)}
+	}
+
+	fsys := fstest.MapFS{
+		"lib/cover.jpg":           {Data: []byte("root")},
+		"lib/Embedded/01.mp3":     song("Embedded", picture),
+		"lib/Plain/01.mp3":        song("Plain", nil),
+		"lib/Own/cover.jpg":       {Data: []byte("own")},
+		"lib/Own/01.mp3":          song("Own", picture),
+		"lib/Mixed/01.mp3":        song("Mixed", nil),
+		"lib/Mixed/02.mp3":        song("Mixed", picture),
+		"lib/Nested/Disc 1/1.mp3": song("Nested", picture),
+	}
+
+	ctx := context.Background()
+	files, _, err := (&Scanner{FS: fsys}).Scan(ctx, []string{"lib"}, nil)
+	if err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	l, err := IndexFiles(ctx, []string{"lib"}, files)
+	if err != nil {
+		t.Fatalf("index: %v", err)
+	}
+
+	rootArt := encodeFileURI("lib/cover.jpg")
+	tests := []struct {
+		album string
+		want  string
+	}{
+		{album: "Embedded", want: embedded},
+		{album: "Plain", want: rootArt},
+		{album: "Own", want: encodeFileURI("lib/Own/cover.jpg")},
+		{album: "Mixed", want: embedded},
+		{album: "Nested", want: embedded},
+	}
+
+	albums := make(map[string]*Node)
+	roots, _ := l.AlbumArtists.Roots(ctx)
+	for _, artist := range roots {
+		for _, album := range artist.Children {
+			albums[album.Name] = album
+		}
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.album, func(t *testing.T) {
+			album, ok := albums[tc.album]
+			if !ok {
+				t.Fatalf("album not indexed")
+			}
+			if album.ImageURI != tc.want {
+				t.Errorf("got album art %q, want %q", album.ImageURI, tc.want)
+			}
+
+			dirPath := "lib/" + tc.album
+			dir, _ := l.Files.Node(ctx, encodeFileURI(dirPath))
+			if dir == nil {
+				t.Fatalf("directory %s not indexed", dirPath)
+			}
+			if dir.ImageURI != tc.want {
+				t.Errorf("got directory art %q, want %q", dir.ImageURI, tc.want)
+			}
+		})
+	}
+}
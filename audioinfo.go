@@ -0,0 +1,587 @@
+package musiclib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"time"
+)
+
+// AudioInfo describes the audio stream of a media file. Zero values mean the
+// value is unknown.
+type AudioInfo struct {
+	Duration time.Duration
+	// Bitrate is the average bitrate in bits per second.
+	Bitrate    int
+	SampleRate int
+	BitDepth   int
+	Channels   int
+}
+
+var errNoAudioInfo = errors.New("no audio stream info found")
+
+// withAverageBitrate fills in the bitrate from the size of the file when the
+// stream doesn't declare one.
+func (a AudioInfo) withAverageBitrate(size int64) AudioInfo {
+	if a.Bitrate == 0 && a.Duration > 0 {
+		a.Bitrate = int(float64(size*8) / a.Duration.Seconds())
+	}
+	return a
+}
+
+func samplesDuration(samples int64, sampleRate int) time.Duration {
+	if sampleRate <= 0 || samples <= 0 {
+		return 0
+	}
+	return time.Duration(float64(samples) / float64(sampleRate) * float64(time.Second))
+}
+
+func readAt(r io.ReadSeeker, offset int64, n int) ([]byte, error) {
+	if _, err := r.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// id3v2Size returns the size of an ID3v2 tag at the start of the file or 0
+// if there is none.
+func id3v2Size(r io.ReadSeeker) (int64, error) {
+	header, err := readAt(r, 0, 10)
+	if err != nil {
+		return 0, err
+	}
+	if string(header[:3]) != "ID3" {
+		return 0, nil
+	}
+	size := int64(header[6])<<21 | int64(header[7])<<14 | int64(header[8])<<7 | int64(header[9])
+	size += 10
+	// footer present
+	if header[5]&0x10 != 0 {
+		size += 10
+	}
+	return size, nil
+}
+
+func readFLACInfo(r io.ReadSeeker, size int64) (AudioInfo, error) {
+	start, err := id3v2Size(r)
+	if err != nil {
+		return AudioInfo{}, err
+	}
+
+	// STREAMINFO is always the first metadata block
+	b, err := readAt(r, start, 4+4+34)
+	if err != nil {
+		return AudioInfo{}, err
+	}
+	if string(b[:4]) != "fLaC" || b[4]&0x7f != 0 {
+		return AudioInfo{}, errNoAudioInfo
+	}
+
+	v := binary.BigEndian.Uint64(b[8+10 : 8+18])
+	sampleRate := int(v >> 44)
+	info := AudioInfo{
+		SampleRate: sampleRate,
+		Channels:   int(v>>41&0x7) + 1,
+		BitDepth:   int(v>>36&0x1f) + 1,
+		Duration:   samplesDuration(int64(v&0xfffffffff), sampleRate),
+	}
+
+	return info.withAverageBitrate(size), nil
+}
+
+var mp3Bitrates = [2][3][16]int{
+	// MPEG 1 layers I, II and III
+	{
+		{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+	},
+	// MPEG 2 and 2.5 layers I, II and III
+	{
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+	},
+}
+
+var mp3SampleRates = [3][3]int{
+	{44100, 48000, 32000}, // MPEG 1
+	{22050, 24000, 16000}, // MPEG 2
+	{11025, 12000, 8000},  // MPEG 2.5
+}
+
+// mp3SearchLen limits how far past the tags the first frame is looked for.
+const mp3SearchLen = 64 << 10
+
+func readMP3Info(r io.ReadSeeker, size int64) (AudioInfo, error) {
+	start, err := id3v2Size(r)
+	if err != nil {
+		return AudioInfo{}, err
+	}
+
+	if _, err := r.Seek(start, io.SeekStart); err != nil {
+		return AudioInfo{}, err
+	}
+	buf := make([]byte, mp3SearchLen)
+	n, err := io.ReadFull(r, buf)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return AudioInfo{}, err
+	}
+	buf = buf[:n]
+
+	for i := 0; i+4 <= len(buf); i++ {
+		if buf[i] != 0xff || buf[i+1]&0xe0 != 0xe0 {
+			continue
+		}
+		info, ok := parseMP3Frame(buf[i:], size-start-int64(i))
+		if ok {
+			return info, nil
+		}
+	}
+
+	return AudioInfo{}, errNoAudioInfo
+}
+
+// validMP3Header reports whether b starts with an MPEG audio frame header
+// without reserved or invalid values.
+func validMP3Header(b []byte) bool {
+	if len(b) < 4 || b[0] != 0xff || b[1]&0xe0 != 0xe0 {
+		return false
+	}
+	versionBits := b[1] >> 3 & 0x3
+	layerBits := b[1] >> 1 & 0x3
+	bitrateIndex := b[2] >> 4
+	sampleRateIndex := b[2] >> 2 & 0x3
+	return versionBits != 1 && layerBits != 0 && bitrateIndex != 0xf && sampleRateIndex != 3
+}
+
+// isMP3FrameHeader reports whether b starts with a valid MPEG layer III
+// frame header. Other layers aren't MP3 and a UTF-16LE byte order mark looks
+// like the start of a layer I header.
+func isMP3FrameHeader(b []byte) bool {
+	return validMP3Header(b) && b[1]>>1&0x3 == 1
+}
+
+// parseMP3Frame reads stream info from the first frame of an MPEG audio
+// stream of audioSize bytes, using a Xing or VBRI header if present.
+func parseMP3Frame(frame []byte, audioSize int64) (AudioInfo, bool) {
+	if !validMP3Header(frame) {
+		return AudioInfo{}, false
+	}
+	versionBits := frame[1] >> 3 & 0x3
+	layerBits := frame[1] >> 1 & 0x3
+	bitrateIndex := frame[2] >> 4
+	sampleRateIndex := frame[2] >> 2 & 0x3
+
+	version := 0 // MPEG 1
+	switch versionBits {
+	case 2:
+		version = 1 // MPEG 2
+	case 0:
+		version = 2 // MPEG 2.5
+	}
+	layer := 3 - int(layerBits) // 0 is layer I
+
+	bitrateVersion := 0
+	if version > 0 {
+		bitrateVersion = 1
+	}
+	bitrate := mp3Bitrates[bitrateVersion][layer][bitrateIndex] * 1000
+	sampleRate := mp3SampleRates[version][sampleRateIndex]
+	channels := 2
+	if frame[3]>>6 == 3 {
+		channels = 1
+	}
+
+	samplesPerFrame := 1152
+	if layer == 0 {
+		samplesPerFrame = 384
+	} else if layer == 2 && version > 0 {
+		samplesPerFrame = 576
+	}
+
+	info := AudioInfo{
+		SampleRate: sampleRate,
+		Channels:   channels,
+	}
+
+	if frames := mp3VBRFrames(frame, version, channels); frames > 0 {
+		info.Duration = samplesDuration(frames*int64(samplesPerFrame), sampleRate)
+		return info.withAverageBitrate(audioSize), true
+	}
+
+	if bitrate == 0 {
+		return AudioInfo{}, false
+	}
+	info.Bitrate = bitrate
+	info.Duration = time.Duration(float64(audioSize*8) / float64(bitrate) * float64(time.Second))
+	return info, true
+}
+
+// mp3VBRFrames returns the frame count from a Xing, Info or VBRI header in
+// the first frame or 0 if there is none.
+func mp3VBRFrames(frame []byte, version int, channels int) int64 {
+	sideInfo := 32
+	if version > 0 && channels == 1 {
+		sideInfo = 9
+	} else if version > 0 || channels == 1 {
+		sideInfo = 17
+	}
+
+	xing := 4 + sideInfo
+	if len(frame) >= xing+12 {
+		id := string(frame[xing : xing+4])
+		flags := binary.BigEndian.Uint32(frame[xing+4 : xing+8])
+		if (id == "Xing" || id == "Info") && flags&0x1 != 0 {
+			return int64(binary.BigEndian.Uint32(frame[xing+8 : xing+12]))
+		}
+	}
+
+	const vbri = 4 + 32
+	if len(frame) >= vbri+18 && string(frame[vbri:vbri+4]) == "VBRI" {
+		return int64(binary.BigEndian.Uint32(frame[vbri+14 : vbri+18]))
+	}
+
+	return 0
+}
+
+// mp4Containers are the atoms that are descended into to find stream info.
+var mp4Containers = map[string]bool{
+	"moov": true,
+	"trak": true,
+	"mdia": true,
+	"minf": true,
+	"stbl": true,
+}
+
+func readMP4Info(r io.ReadSeeker, size int64) (AudioInfo, error) {
+	var info AudioInfo
+	if err := readMP4Atoms(r, 0, size, &info); err != nil {
+		return AudioInfo{}, err
+	}
+	if info.Duration == 0 && info.SampleRate == 0 {
+		return AudioInfo{}, errNoAudioInfo
+	}
+	return info.withAverageBitrate(size), nil
+}
+
+func readMP4Atoms(r io.ReadSeeker, start int64, end int64, info *AudioInfo) error {
+	for offset := start; offset+8 <= end; {
+		header, err := readAt(r, offset, 8)
+		if err != nil {
+			return err
+		}
+		atomSize := int64(binary.BigEndian.Uint32(header[:4]))
+		name := string(header[4:8])
+		headerSize := int64(8)
+		switch atomSize {
+		case 0:
+			atomSize = end - offset
+		case 1:
+			large, err := readAt(r, offset+8, 8)
+			if err != nil {
+				return err
+			}
+			atomSize = int64(binary.BigEndian.Uint64(large))
+			headerSize = 16
+		}
+		if atomSize < headerSize || offset+atomSize > end {
+			return errors.New("invalid mp4 atom size")
+		}
+
+		body := offset + headerSize
+		switch {
+		case mp4Containers[name]:
+			if err := readMP4Atoms(r, body, offset+atomSize, info); err != nil {
+				return err
+			}
+		case name == "mvhd":
+			if err := readMP4MovieHeader(r, body, info); err != nil {
+				return err
+			}
+		case name == "stsd" && info.SampleRate == 0:
+			if err := readMP4SampleDescription(r, body, info); err != nil {
+				return err
+			}
+		}
+
+		offset += atomSize
+	}
+	return nil
+}
+
+func readMP4MovieHeader(r io.ReadSeeker, body int64, info *AudioInfo) error {
+	b, err := readAt(r, body, 32)
+	if err != nil {
+		return err
+	}
+
+	var timescale, duration uint64
+	if b[0] == 1 {
+		timescale = uint64(binary.BigEndian.Uint32(b[20:24]))
+		duration = binary.BigEndian.Uint64(b[24:32])
+	} else {
+		timescale = uint64(binary.BigEndian.Uint32(b[12:16]))
+		duration = uint64(binary.BigEndian.Uint32(b[16:20]))
+	}
+	if timescale > 0 {
+		info.Duration = time.Duration(float64(duration) / float64(timescale) * float64(time.Second))
+	}
+	return nil
+}
+
+func readMP4SampleDescription(r io.ReadSeeker, body int64, info *AudioInfo) error {
+	// version, flags and entry count precede the first sample entry, which
+	// has an 8 byte header followed by the audio sample entry fields
+	b, err := readAt(r, body+8, 8+28)
+	if err != nil {
+		return err
+	}
+	entry := b[8:]
+	switch string(b[4:8]) {
+	case "mp4a", "alac":
+	default:
+		return nil
+	}
+
+	info.Channels = int(binary.BigEndian.Uint16(entry[16:18]))
+	info.BitDepth = int(binary.BigEndian.Uint16(entry[18:20]))
+	info.SampleRate = int(binary.BigEndian.Uint32(entry[24:28]) >> 16)
+	// lossy streams report a nominal sample size
+	if string(b[4:8]) == "mp4a" {
+		info.BitDepth = 0
+		return nil
+	}
+
+	entrySize := int64(binary.BigEndian.Uint32(b[:4]))
+	version := binary.BigEndian.Uint16(entry[8:10])
+	return readALACConfig(r, body+8, entrySize, version, info)
+}
+
+// readALACConfig reads stream info from the alac atom nested in an ALAC
+// sample entry of entrySize bytes at start. Its sample rate isn't limited to
+// the 16 bits of the sample entry's fixed point rate, so rates such as
+// 96 kHz are read correctly.
+func readALACConfig(r io.ReadSeeker, start int64, entrySize int64, version uint16, info *AudioInfo) error {
+	// QuickTime sound sample descriptions add fields in versions 1 and 2
+	offset := start + 8 + 28
+	switch version {
+	case 1:
+		offset += 16
+	case 2:
+		offset += 36
+	}
+
+	end := start + entrySize
+	for offset+8 <= end {
+		header, err := readAt(r, offset, 8)
+		if err != nil {
+			return err
+		}
+		atomSize := int64(binary.BigEndian.Uint32(header[:4]))
+		if atomSize < 8 || offset+atomSize > end {
+			return errors.New("invalid alac sample entry")
+		}
+		// version and flags precede the 24 byte ALACSpecificConfig
+		if string(header[4:8]) == "alac" && atomSize >= 8+4+24 {
+			config, err := readAt(r, offset+12, 24)
+			if err != nil {
+				return err
+			}
+			info.BitDepth = int(config[5])
+			info.Channels = int(config[9])
+			info.SampleRate = int(binary.BigEndian.Uint32(config[20:24]))
+			return nil
+		}
+		offset += atomSize
+	}
+	return nil
+}
+
+// oggTailLen is how much of the end of an Ogg file is searched for the last
+// page.
+const oggTailLen = 64 << 10
+
+func readOggInfo(r io.ReadSeeker, size int64) (AudioInfo, error) {
+	page, err := readAt(r, 0, 27)
+	if err != nil {
+		return AudioInfo{}, err
+	}
+	if string(page[:4]) != "OggS" {
+		return AudioInfo{}, errNoAudioInfo
+	}
+	segments, err := readAt(r, 27, int(page[26]))
+	if err != nil {
+		return AudioInfo{}, err
+	}
+	packetLen := 0
+	for _, s := range segments {
+		packetLen += int(s)
+	}
+	packet, err := readAt(r, 27+int64(len(segments)), packetLen)
+	if err != nil {
+		return AudioInfo{}, err
+	}
+
+	var info AudioInfo
+	var preSkip int64
+	var granuleRate int
+	switch {
+	case len(packet) >= 19 && string(packet[:8]) == "OpusHead":
+		info.Channels = int(packet[9])
+		preSkip = int64(binary.LittleEndian.Uint16(packet[10:12]))
+		info.SampleRate = int(binary.LittleEndian.Uint32(packet[12:16]))
+		// opus granule positions always count 48kHz samples
+		granuleRate = 48000
+	case len(packet) >= 30 && string(packet[:7]) == "\x01vorbis":
+		info.Channels = int(packet[11])
+		info.SampleRate = int(binary.LittleEndian.Uint32(packet[12:16]))
+		info.Bitrate = int(int32(binary.LittleEndian.Uint32(packet[20:24])))
+		if info.Bitrate < 0 {
+			info.Bitrate = 0
+		}
+		granuleRate = info.SampleRate
+	default:
+		return AudioInfo{}, errNoAudioInfo
+	}
+
+	granule, err := lastOggGranule(r, size)
+	if err != nil {
+		return AudioInfo{}, err
+	}
+	info.Duration = samplesDuration(granule-preSkip, granuleRate)
+
+	return info.withAverageBitrate(size), nil
+}
+
+// lastOggGranule returns the granule position of the last page in the file.
+func lastOggGranule(r io.ReadSeeker, size int64) (int64, error) {
+	start := max(size-oggTailLen, 0)
+	tail, err := readAt(r, start, int(size-start))
+	if err != nil {
+		return 0, err
+	}
+
+	i := bytes.LastIndex(tail, []byte("OggS"))
+	if i < 0 || i+14 > len(tail) {
+		return 0, errNoAudioInfo
+	}
+	return int64(binary.LittleEndian.Uint64(tail[i+6 : i+14])), nil
+}
+
+func readWAVInfo(r io.ReadSeeker, size int64) (AudioInfo, error) {
+	var info AudioInfo
+	var byteRate int64
+	err := walkChunks(r, 12, size, 4, binary.LittleEndian, func(id string, offset int64, chunkSize int64) (bool, error) {
+		switch id {
+		case "fmt ":
+			b, err := readAt(r, offset, 16)
+			if err != nil {
+				return false, err
+			}
+			info.Channels = int(binary.LittleEndian.Uint16(b[2:4]))
+			info.SampleRate = int(binary.LittleEndian.Uint32(b[4:8]))
+			byteRate = int64(binary.LittleEndian.Uint32(b[8:12]))
+			info.BitDepth = int(binary.LittleEndian.Uint16(b[14:16]))
+			info.Bitrate = int(byteRate * 8)
+		case "data":
+			// streamed files may not know their data size and leave it at
+			// the maximum
+			chunkSize = min(chunkSize, size-offset)
+			if byteRate > 0 {
+				info.Duration = time.Duration(float64(chunkSize) / float64(byteRate) * float64(time.Second))
+			}
+			return true, nil
+		}
+		return false, nil
+	})
+	if err != nil {
+		return AudioInfo{}, err
+	}
+	if info.SampleRate == 0 {
+		return AudioInfo{}, errNoAudioInfo
+	}
+	return info, nil
+}
+
+// aiffMaxSampleRate bounds the sample rates read from AIFF files.
+const aiffMaxSampleRate = 1 << 24
+
+func readAIFFInfo(r io.ReadSeeker, size int64) (AudioInfo, error) {
+	var info AudioInfo
+	err := walkChunks(r, 12, size, 4, binary.BigEndian, func(id string, offset int64, chunkSize int64) (bool, error) {
+		if id != "COMM" {
+			return false, nil
+		}
+		b, err := readAt(r, offset, 18)
+		if err != nil {
+			return false, err
+		}
+		info.Channels = int(binary.BigEndian.Uint16(b[0:2]))
+		frames := int64(binary.BigEndian.Uint32(b[2:6]))
+		info.BitDepth = int(binary.BigEndian.Uint16(b[6:8]))
+		// corrupt rates may be far out of range or not even finite
+		if rate := extendedFloat(b[8:18]); rate >= 1 && rate <= aiffMaxSampleRate {
+			info.SampleRate = int(rate)
+		}
+		info.Duration = samplesDuration(frames, info.SampleRate)
+		return true, nil
+	})
+	if err != nil {
+		return AudioInfo{}, err
+	}
+	if info.SampleRate == 0 {
+		return AudioInfo{}, errNoAudioInfo
+	}
+	return info.withAverageBitrate(size), nil
+}
+
+// extendedFloat decodes an 80 bit IEEE 754 extended precision number as used
+// for AIFF sample rates.
+func extendedFloat(b []byte) float64 {
+	exponent := int(binary.BigEndian.Uint16(b[0:2]) & 0x7fff)
+	mantissa := binary.BigEndian.Uint64(b[2:10])
+	if exponent == 0 && mantissa == 0 {
+		return 0
+	}
+	f := float64(mantissa) * math.Pow(2, float64(exponent-16383-63))
+	if b[0]&0x80 != 0 {
+		f = -f
+	}
+	return f
+}
+
+// walkChunks calls fn with the id, data offset and size of each chunk of an
+// IFF style container until fn returns true.
+func walkChunks(r io.ReadSeeker, start int64, end int64, sizeLen int, order binary.ByteOrder, fn func(id string, offset int64, size int64) (bool, error)) error {
+	for offset := start; offset+4+int64(sizeLen) <= end; {
+		header, err := readAt(r, offset, 4+sizeLen)
+		if err != nil {
+			return err
+		}
+		var size int64
+		if sizeLen == 8 {
+			size = int64(order.Uint64(header[4:]))
+		} else {
+			size = int64(order.Uint32(header[4:]))
+		}
+		if size < 0 {
+			return errors.New("invalid chunk size")
+		}
+
+		done, err := fn(string(header[:4]), offset+4+int64(sizeLen), size)
+		if err != nil || done {
+			return err
+		}
+
+		// chunks are padded to an even size
+		offset += 4 + int64(sizeLen) + size + size%2
+	}
+	return nil
+}
@@ -0,0 +1,382 @@
+package musiclib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func be16(v uint16) []byte {
+	return binary.BigEndian.AppendUint16(nil, v)
+}
+
+func be32(v uint32) []byte {
+	return binary.BigEndian.AppendUint32(nil, v)
+}
+
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func mp4Atom(name string, body ...[]byte) []byte {
+	data := concat(body...)
+	return concat(be32(uint32(8+len(data))), []byte(name), data)
+}
+
+// mp4Audio returns an MP4 file with one track described by sampleEntry.
+func mp4Audio(timescale uint32, duration uint32, sampleEntry []byte) []byte {
+	mvhd := mp4Atom("mvhd", make([]byte, 12), be32(timescale), be32(duration), make([]byte, 80))
+	stsd := mp4Atom("stsd", be32(0), be32(1), sampleEntry)
+	return concat(
+		mp4Atom("ftyp", []byte("M4A "), be32(0)),
+		mp4Atom("moov", mvhd,
+			mp4Atom("trak", mp4Atom("mdia", mp4Atom("minf", mp4Atom("stbl", stsd))))),
+	)
+}
+
+// mp4SampleEntry returns an audio sample entry version 0. Like real files,
+// rate is stored as 16.16 fixed point and so wraps above 65535 Hz.
+func mp4SampleEntry(format string, channels uint16, sampleSize uint16, rate uint32, nested ...[]byte) []byte {
+	fields := concat(make([]byte, 6), be16(1), make([]byte, 8),
+		be16(channels), be16(sampleSize), be16(0), be16(0), be32(rate<<16))
+	return mp4Atom(format, append([][]byte{fields}, nested...)...)
+}
+
+func alacConfig(bitDepth uint8, channels uint8, rate uint32) []byte {
+	config := concat(be32(4096), []byte{0, bitDepth, 40, 10, 14, channels},
+		be16(255), be32(0), be32(0), be32(rate))
+	return mp4Atom("alac", be32(0), config)
+}
+
+func TestReadMP4Info(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		want    AudioInfo
+		wantErr bool
+	}{
+		{
+			name: "aac",
+			data: mp4Audio(44100, 44100*3, mp4SampleEntry("mp4a", 2, 16, 44100)),
+			want: AudioInfo{Duration: 3 * time.Second, SampleRate: 44100, Channels: 2},
+		},
+		{
+			name: "alac 44.1 kHz",
+			data: mp4Audio(44100, 44100*2, mp4SampleEntry("alac", 2, 16, 44100, alacConfig(16, 2, 44100))),
+			want: AudioInfo{Duration: 2 * time.Second, SampleRate: 44100, BitDepth: 16, Channels: 2},
+		},
+		{
+			name: "alac 96 kHz",
+			data: mp4Audio(96000, 96000*2, mp4SampleEntry("alac", 2, 24, 96000, alacConfig(24, 2, 96000))),
+			want: AudioInfo{Duration: 2 * time.Second, SampleRate: 96000, BitDepth: 24, Channels: 2},
+		},
+		{
+			name: "alac 192 kHz",
+			data: mp4Audio(1000, 2000, mp4SampleEntry("alac", 2, 24, 192000, alacConfig(24, 2, 192000))),
+			want: AudioInfo{Duration: 2 * time.Second, SampleRate: 192000, BitDepth: 24, Channels: 2},
+		},
+		{
+			name: "alac without config",
+			data: mp4Audio(1000, 2000, mp4SampleEntry("alac", 1, 16, 48000)),
+			want: AudioInfo{Duration: 2 * time.Second, SampleRate: 48000, BitDepth: 16, Channels: 1},
+		},
+		{
+			name:    "alac config overruns entry",
+			data:    mp4Audio(1000, 2000, mp4SampleEntry("alac", 2, 16, 48000, concat(be32(64), []byte("alac")))),
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := readMP4Info(bytes.NewReader(tc.data), int64(len(tc.data)))
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("got %+v, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readMP4Info: %v", err)
+			}
+			// the average bitrate depends on the fixture's size
+			got.Bitrate = 0
+			if got != tc.want {
+				t.Errorf("got %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func le16(v uint16) []byte {
+	return binary.LittleEndian.AppendUint16(nil, v)
+}
+
+func le64(v uint64) []byte {
+	return binary.LittleEndian.AppendUint64(nil, v)
+}
+
+func padTo(b []byte, n int) []byte {
+	return append(b, make([]byte, max(n-len(b), 0))...)
+}
+
+func flacStream(sampleRate uint64, channels uint64, bitDepth uint64, samples uint64) []byte {
+	v := sampleRate<<44 | (channels-1)<<41 | (bitDepth-1)<<36 | samples
+	streamInfo := concat(make([]byte, 10), binary.BigEndian.AppendUint64(nil, v), make([]byte, 16))
+	return concat([]byte("fLaC"), []byte{0x80, 0, 0, 34}, streamInfo, make([]byte, 100))
+}
+
+// oggPage returns an Ogg page holding one packet of less than 255 bytes.
+func oggPage(granule uint64, packet []byte) []byte {
+	return concat([]byte("OggS"), []byte{0, 0}, le64(granule), le32(1), le32(0), le32(0),
+		[]byte{1, byte(len(packet))}, packet)
+}
+
+func opusHead(channels byte, preSkip uint16, sampleRate uint32) []byte {
+	return concat([]byte("OpusHead"), []byte{1, channels}, le16(preSkip), le32(sampleRate), []byte{0, 0, 0})
+}
+
+func vorbisID(channels byte, sampleRate uint32, bitrate uint32) []byte {
+	return concat([]byte("\x01vorbis"), le32(0), []byte{channels}, le32(sampleRate),
+		le32(0), le32(bitrate), le32(0), []byte{0xb8, 1})
+}
+
+func wavFile(fmtChunk []byte, dataSize uint32, data []byte) []byte {
+	return concat([]byte("RIFF"), le32(0), []byte("WAVE"),
+		iffChunk("fmt ", 4, binary.LittleEndian, fmtChunk),
+		[]byte("data"), le32(dataSize), data)
+}
+
+func wavFormat(channels uint16, sampleRate uint32, bitDepth uint16) []byte {
+	blockAlign := channels * bitDepth / 8
+	return concat(le16(1), le16(channels), le32(sampleRate), le32(sampleRate*uint32(blockAlign)),
+		le16(blockAlign), le16(bitDepth))
+}
+
+// extended80 encodes an integer as an 80 bit extended precision float.
+func extended80(v uint64) []byte {
+	exponent := 63
+	for v>>63 == 0 {
+		v <<= 1
+		exponent--
+	}
+	return concat(be16(uint16(16383+exponent)), binary.BigEndian.AppendUint64(nil, v))
+}
+
+func aiffFile(chunks ...[]byte) []byte {
+	return concat(append([][]byte{[]byte("FORM"), be32(0), []byte("AIFF")}, chunks...)...)
+}
+
+func aiffComm(channels uint16, frames uint32, bitDepth uint16, rate []byte) []byte {
+	return iffChunk("COMM", 4, binary.BigEndian, concat(be16(channels), be32(frames), be16(bitDepth), rate))
+}
+
+func TestReadAudioInfo(t *testing.T) {
+	id3 := id3v23(map[string]string{"TIT2": "Song"}, nil)
+	id3 = id3[:len(id3)-len(mp3Frame)]
+
+	xingFrame := concat([]byte{0xff, 0xfb, 0x90, 0x64}, make([]byte, 32), []byte("Xing"), be32(1), be32(100))
+	vbriFrame := concat([]byte{0xff, 0xfb, 0x90, 0x64}, make([]byte, 32), []byte("VBRI"), make([]byte, 10), be32(200))
+
+	tests := []struct {
+		name    string
+		read    InfoReader
+		data    []byte
+		want    AudioInfo
+		wantErr bool
+	}{
+		{
+			name: "flac",
+			read: readFLACInfo,
+			data: flacStream(44100, 2, 16, 441000),
+			want: AudioInfo{Duration: 10 * time.Second, SampleRate: 44100, BitDepth: 16, Channels: 2},
+		},
+		{
+			name: "flac hi-res after id3",
+			read: readFLACInfo,
+			data: concat(id3, flacStream(96000, 2, 24, 96000*3)),
+			want: AudioInfo{Duration: 3 * time.Second, SampleRate: 96000, BitDepth: 24, Channels: 2},
+		},
+		{
+			name:    "flac truncated",
+			read:    readFLACInfo,
+			data:    flacStream(44100, 2, 16, 1)[:20],
+			wantErr: true,
+		},
+		{
+			name:    "flac without streaminfo first",
+			read:    readFLACInfo,
+			data:    concat([]byte("fLaC"), []byte{0x84, 0, 0, 34}, make([]byte, 34)),
+			wantErr: true,
+		},
+		{
+			name:    "not flac",
+			read:    readFLACInfo,
+			data:    make([]byte, 100),
+			wantErr: true,
+		},
+		{
+			name: "mp3 cbr",
+			read: readMP3Info,
+			data: padTo(mp3Frame, 16000),
+			want: AudioInfo{Duration: time.Second, Bitrate: 128000, SampleRate: 44100, Channels: 2},
+		},
+		{
+			name: "mp3 cbr after id3 and junk",
+			read: readMP3Info,
+			data: concat(id3, []byte{0, 0xff, 0xfe}, padTo(mp3Frame, 16000)),
+			want: AudioInfo{Duration: time.Second, Bitrate: 128000, SampleRate: 44100, Channels: 2},
+		},
+		{
+			name: "mp3 xing",
+			read: readMP3Info,
+			data: padTo(xingFrame, 417),
+			want: AudioInfo{Duration: samplesDuration(100*1152, 44100), SampleRate: 44100, Channels: 2},
+		},
+		{
+			name: "mp3 vbri",
+			read: readMP3Info,
+			data: padTo(vbriFrame, 417),
+			want: AudioInfo{Duration: samplesDuration(200*1152, 44100), SampleRate: 44100, Channels: 2},
+		},
+		{
+			name:    "mp3 without frames",
+			read:    readMP3Info,
+			data:    make([]byte, 1000),
+			wantErr: true,
+		},
+		{
+			name: "mp3 invalid headers",
+			read: readMP3Info,
+			data: padTo([]byte{
+				0xff, 0xfb, 0xf0, 0x64, // bad bitrate
+				0xff, 0xeb, 0x90, 0x64, // reserved version
+				0xff, 0xf9, 0x90, 0x64, // reserved layer
+				0xff, 0xfb, 0x9c, 0x64, // reserved sample rate
+			}, 100),
+			wantErr: true,
+		},
+		{
+			name:    "mp3 truncated",
+			read:    readMP3Info,
+			data:    []byte{0xff, 0xfb},
+			wantErr: true,
+		},
+		{
+			name: "opus",
+			read: readOggInfo,
+			data: concat(oggPage(0, opusHead(2, 312, 44100)), oggPage(48000*5+312, []byte("audio"))),
+			want: AudioInfo{Duration: 5 * time.Second, SampleRate: 44100, Channels: 2},
+		},
+		{
+			name: "vorbis",
+			read: readOggInfo,
+			data: concat(oggPage(0, vorbisID(1, 22050, 160000)), oggPage(22050*7, []byte("audio"))),
+			want: AudioInfo{Duration: 7 * time.Second, Bitrate: 160000, SampleRate: 22050, Channels: 1},
+		},
+		{
+			name: "vorbis unknown length",
+			read: readOggInfo,
+			data: oggPage(^uint64(0), vorbisID(2, 44100, 0)),
+			want: AudioInfo{SampleRate: 44100, Channels: 2},
+		},
+		{
+			name:    "ogg unknown codec",
+			read:    readOggInfo,
+			data:    oggPage(0, []byte("fishead\x00 skeleton")),
+			wantErr: true,
+		},
+		{
+			name:    "ogg truncated packet",
+			read:    readOggInfo,
+			data:    oggPage(0, opusHead(2, 0, 48000))[:35],
+			wantErr: true,
+		},
+		{
+			name:    "not ogg",
+			read:    readOggInfo,
+			data:    make([]byte, 100),
+			wantErr: true,
+		},
+		{
+			name: "wav",
+			read: readWAVInfo,
+			data: wavFile(wavFormat(2, 44100, 16), 17640, make([]byte, 17640)),
+			want: AudioInfo{Duration: 100 * time.Millisecond, Bitrate: 1411200, SampleRate: 44100, BitDepth: 16, Channels: 2},
+		},
+		{
+			name: "wav streamed with unknown data size",
+			read: readWAVInfo,
+			data: wavFile(wavFormat(1, 8000, 8), 0xffffffff, make([]byte, 4000)),
+			want: AudioInfo{Duration: 500 * time.Millisecond, Bitrate: 64000, SampleRate: 8000, BitDepth: 8, Channels: 1},
+		},
+		{
+			name:    "wav without fmt",
+			read:    readWAVInfo,
+			data:    concat([]byte("RIFF"), le32(0), []byte("WAVE"), []byte("data"), le32(4), make([]byte, 4)),
+			wantErr: true,
+		},
+		{
+			name:    "wav truncated fmt",
+			read:    readWAVInfo,
+			data:    concat([]byte("RIFF"), le32(0), []byte("WAVE"), []byte("fmt "), le32(16), make([]byte, 4)),
+			wantErr: true,
+		},
+		{
+			name: "aiff",
+			read: readAIFFInfo,
+			data: aiffFile(aiffComm(1, 44100*3, 24, extended80(44100)), iffChunk("SSND", 4, binary.BigEndian, make([]byte, 8))),
+			want: AudioInfo{Duration: 3 * time.Second, SampleRate: 44100, BitDepth: 24, Channels: 1},
+		},
+		{
+			name:    "aiff corrupt sample rate",
+			read:    readAIFFInfo,
+			data:    aiffFile(aiffComm(2, 100, 16, concat(be16(0x7fff), be32(0xffffffff), be32(0xffffffff)))),
+			wantErr: true,
+		},
+		{
+			name:    "aiff without comm",
+			read:    readAIFFInfo,
+			data:    aiffFile(iffChunk("SSND", 4, binary.BigEndian, make([]byte, 8))),
+			wantErr: true,
+		},
+		{
+			name:    "aiff truncated comm",
+			read:    readAIFFInfo,
+			data:    aiffFile(aiffComm(2, 100, 16, extended80(48000)))[:24],
+			wantErr: true,
+		},
+		{
+			name:    "mp4 truncated",
+			read:    readMP4Info,
+			data:    mp4Audio(44100, 44100, mp4SampleEntry("mp4a", 2, 16, 44100))[:40],
+			wantErr: true,
+		},
+		{
+			name:    "mp4 without movie",
+			read:    readMP4Info,
+			data:    mp4Atom("ftyp", []byte("M4A "), be32(0)),
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tc.read(bytes.NewReader(tc.data), int64(len(tc.data)))
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("got %+v, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			want := tc.want.withAverageBitrate(int64(len(tc.data)))
+			if got != want {
+				t.Errorf("got %+v, want %+v", got, want)
+			}
+		})
+	}
+}
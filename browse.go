@@ -1,6 +1,7 @@
 package musiclib
 
 import (
+	"bytes"
 	"context"
 	"net/url"
 	"strings"
@@ -16,9 +17,41 @@ const (
 	BrowseTypeModified    BrowseType = "modified"
 )
 
+// SearchMode selects how BrowseOptions.TextFilter is interpreted.
+type SearchMode string
+
+const (
+	// SearchModeText matches names containing the text.
+	SearchModeText SearchMode = ""
+	// SearchModeQuery matches songs with a query parsed by ParseQuery.
+	SearchModeQuery SearchMode = "query"
+	// SearchModeFuzzy returns the nodes at any depth whose names are most
+	// similar to the text, best first, tolerating typos.
+	SearchModeFuzzy SearchMode = "fuzzy"
+)
+
 type BrowseOptions struct {
+	// TextFilter matches names containing the text, ignoring case and
+	// diacritics, or is a query depending on SearchMode.
 	TextFilter string
+	SearchMode SearchMode
 	BrowseType BrowseType
+	// Offset skips the first results.
+	Offset int
+	// Limit is the most results to return. Values less than 1 return all
+	// results.
+	Limit int
+	// Cursor continues from the NextCursor of a previous page of the same
+	// request instead of Offset. Using it with a different URI, filter or
+	// sort fails with ErrCursorMismatch.
+	Cursor string
+	// Sort orders the results at each level.
+	Sort       SortKey
+	Descending bool
+	// RandomSeed seeds SortRandom. Requests with the same seed against the
+	// same library return the same order. Zero uses a new seed for each
+	// request, which BrowsePage and MediaPage keep in their cursors.
+	RandomSeed int64
 }
 
 type BrowseItem struct {
@@ -26,6 +59,9 @@ type BrowseItem struct {
 	URI      string
 	ImageURI string
 	Folder   bool
+	// Score is the relevance of a fuzzy search result from 0 to 1.
+	Score float64
+	Stats NodeStats
 }
 
 type Index interface {
@@ -36,12 +72,23 @@ type Index interface {
 type WalkNodeFunc func(n *Node) error
 
 type Node struct {
-	Name      string
+	Name string
+	// LowerName is Name normalized for matching text filters.
 	LowerName string
-	URI       string
-	ImageURI  string
-	Parent    *Node
-	Children  []*Node
+	// SortName orders the node among its siblings. It comes from sort tags
+	// or the name without a leading article.
+	SortName string
+	URI      string
+	ImageURI string
+	Parent   *Node
+	Children []*Node
+	// Stats summarizes the songs below the node. It is computed when the
+	// index is built.
+	Stats NodeStats
+	// file is the media file a leaf was built from, if any.
+	file *PathMeta
+	// sortKey is the collation key of SortName.
+	sortKey []byte
 }
 
 func (n *Node) AddChildren(nodes ...*Node) {
@@ -67,16 +114,41 @@ func (n *Node) walkLeaves(walkFn WalkNodeFunc) error {
 
 func nameSort(nodes []*Node) func(i, j int) bool {
 	return func(i, j int) bool {
+		if c := bytes.Compare(nodes[i].sortKey, nodes[j].sortKey); c != 0 {
+			return c < 0
+		}
 		return nodes[i].LowerName < nodes[j].LowerName
 	}
 }
 
+// trackSort orders leaves by disc, track and then file name. Leaves without
+// a file sort by name after those with one.
+func trackSort(nodes []*Node) func(i, j int) bool {
+	return func(i, j int) bool {
+		a, b := nodes[i].file, nodes[j].file
+		if a == nil || b == nil {
+			if a != b {
+				return b == nil
+			}
+			return nodes[i].LowerName < nodes[j].LowerName
+		}
+		if a.Metadata.Disc() != b.Metadata.Disc() {
+			return a.Metadata.Disc() < b.Metadata.Disc()
+		}
+		if a.Metadata.Track() != b.Metadata.Track() {
+			return a.Metadata.Track() < b.Metadata.Track()
+		}
+		return a.Name < b.Name
+	}
+}
+
 func toBrowseItem(n *Node) *BrowseItem {
 	return &BrowseItem{
 		Name:     n.Name,
 		URI:      n.URI,
 		ImageURI: n.ImageURI,
 		Folder:   len(n.Children) > 0,
+		Stats:    n.Stats,
 	}
 }
 
@@ -99,6 +171,9 @@ func encodeFileURI(filePath string) string {
 	u := &url.URL{
 		Scheme: "file",
 		Path:   filePath,
+		// paths relative to an fs.FS leave out the empty authority so their
+		// first element isn't read as a host
+		OmitHost: !strings.HasPrefix(filePath, "/"),
 	}
 	return u.String()
 }
@@ -0,0 +1,302 @@
+package musiclib
+
+import (
+	"compress/gzip"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// cacheVersion must be incremented whenever the cached data changes shape or
+// meaning so stale caches are rebuilt instead of misread.
+const cacheVersion = 9
+
+// ErrCacheOutdated is returned when a cache was written by a different cache
+// version or for different root paths.
+var ErrCacheOutdated = errors.New("scan cache is outdated")
+
+type cacheHeader struct {
+	Version   int
+	RootPaths []string
+}
+
+type cachedPath struct {
+	Name           string
+	Path           string
+	ImagePath      string
+	ImageInherited bool
+	Size           int64
+	ModTime        time.Time
+	Metadata       *cachedMetadata
+	Children       []cachedPath
+}
+
+type cachedMetadata struct {
+	Artist          string
+	AlbumArtist     string
+	Album           string
+	Song            string
+	ArtistSort      string
+	AlbumArtistSort string
+	AlbumSort       string
+	AlbumArtURI     string
+	Track           int
+	Disc            int
+	DiscTotal       int
+	Genre           string
+	Compilation     bool
+	Modified        time.Time
+	Year            int
+	Duration        time.Duration
+	Bitrate         int
+	SampleRate      int
+	BitDepth        int
+	Channels        int
+}
+
+// WriteCache serializes scanned files so they can be restored by ReadCache
+// without reading tags again.
+func WriteCache(w io.Writer, rootPaths []string, files *Files) error {
+	zw := gzip.NewWriter(w)
+	enc := gob.NewEncoder(zw)
+
+	header := cacheHeader{
+		Version:   cacheVersion,
+		RootPaths: rootPaths,
+	}
+	if err := enc.Encode(header); err != nil {
+		return fmt.Errorf("encode header: %v", err)
+	}
+
+	roots := make([]cachedPath, 0, len(files.Roots))
+	for _, root := range files.Roots {
+		roots = append(roots, toCachedPath(&root))
+	}
+	if err := enc.Encode(roots); err != nil {
+		return fmt.Errorf("encode roots: %v", err)
+	}
+
+	return zw.Close()
+}
+
+// ReadCache restores files written by WriteCache. fsys is the filesystem the
+// files were scanned from, or nil for the operating system's filesystem.
+// ErrCacheOutdated is returned if the cache is not usable for rootPaths.
+func ReadCache(r io.Reader, rootPaths []string, fsys fs.FS) (*Files, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("open cache: %v", err)
+	}
+	defer zr.Close()
+	dec := gob.NewDecoder(zr)
+
+	var header cacheHeader
+	if err := dec.Decode(&header); err != nil {
+		return nil, fmt.Errorf("decode header: %v", err)
+	}
+	if header.Version != cacheVersion || !slices.Equal(header.RootPaths, rootPaths) {
+		return nil, ErrCacheOutdated
+	}
+
+	var roots []cachedPath
+	if err := dec.Decode(&roots); err != nil {
+		return nil, fmt.Errorf("decode roots: %v", err)
+	}
+
+	files := &Files{fsys: fsys}
+	for _, root := range roots {
+		files.Roots = append(files.Roots, *fromCachedPath(&root))
+	}
+
+	return files, nil
+}
+
+// WriteCacheFile atomically replaces the cache at cachePath.
+func WriteCacheFile(cachePath string, rootPaths []string, files *Files) error {
+	if err := os.MkdirAll(filepath.Dir(cachePath), 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(cachePath), filepath.Base(cachePath)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := WriteCache(tmp, rootPaths, files); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), cachePath)
+}
+
+// ReadCacheFile restores files from the cache at cachePath.
+func ReadCacheFile(cachePath string, rootPaths []string, fsys fs.FS) (*Files, error) {
+	f, err := os.Open(cachePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ReadCache(f, rootPaths, fsys)
+}
+
+func toCachedPath(p *PathMeta) cachedPath {
+	cached := cachedPath{
+		Name:           p.Name,
+		Path:           p.Path,
+		ImagePath:      p.ImagePath,
+		ImageInherited: p.ImageInherited,
+		Size:           p.Size,
+		ModTime:        p.ModTime,
+	}
+
+	if p.Metadata != nil {
+		cached.Metadata = &cachedMetadata{
+			Artist:          p.Metadata.Artist(),
+			AlbumArtist:     p.Metadata.AlbumArtist(),
+			Album:           p.Metadata.Album(),
+			Song:            p.Metadata.Song(),
+			ArtistSort:      p.Metadata.ArtistSort(),
+			AlbumArtistSort: p.Metadata.AlbumArtistSort(),
+			AlbumSort:       p.Metadata.AlbumSort(),
+			AlbumArtURI:     p.Metadata.AlbumArtURI(),
+			Track:           p.Metadata.Track(),
+			Disc:            p.Metadata.Disc(),
+			DiscTotal:       p.Metadata.DiscTotal(),
+			Genre:           p.Metadata.Genre(),
+			Compilation:     p.Metadata.Compilation(),
+			Modified:        p.Metadata.Modified(),
+			Year:            p.Metadata.Year(),
+			Duration:        p.Metadata.Duration(),
+			Bitrate:         p.Metadata.Bitrate(),
+			SampleRate:      p.Metadata.SampleRate(),
+			BitDepth:        p.Metadata.BitDepth(),
+			Channels:        p.Metadata.Channels(),
+		}
+	}
+
+	for _, child := range p.Children {
+		cached.Children = append(cached.Children, toCachedPath(&child))
+	}
+
+	return cached
+}
+
+func fromCachedPath(cached *cachedPath) *PathMeta {
+	meta := &PathMeta{
+		Name:           cached.Name,
+		Path:           cached.Path,
+		ImagePath:      cached.ImagePath,
+		ImageInherited: cached.ImageInherited,
+		Size:           cached.Size,
+		ModTime:        cached.ModTime,
+	}
+
+	if cached.Metadata != nil {
+		meta.Metadata = &storedMetadata{m: *cached.Metadata}
+	}
+
+	for _, cachedChild := range cached.Children {
+		child := fromCachedPath(&cachedChild)
+		child.Parent = meta
+		meta.Children = append(meta.Children, *child)
+	}
+
+	return meta
+}
+
+// storedMetadata serves metadata restored from a cache.
+type storedMetadata struct {
+	m cachedMetadata
+}
+
+func (s *storedMetadata) Artist() string {
+	return s.m.Artist
+}
+
+func (s *storedMetadata) AlbumArtist() string {
+	return s.m.AlbumArtist
+}
+
+func (s *storedMetadata) Album() string {
+	return s.m.Album
+}
+
+func (s *storedMetadata) Song() string {
+	return s.m.Song
+}
+
+func (s *storedMetadata) ArtistSort() string {
+	return s.m.ArtistSort
+}
+
+func (s *storedMetadata) AlbumArtistSort() string {
+	return s.m.AlbumArtistSort
+}
+
+func (s *storedMetadata) AlbumSort() string {
+	return s.m.AlbumSort
+}
+
+func (s *storedMetadata) AlbumArtURI() string {
+	return s.m.AlbumArtURI
+}
+
+func (s *storedMetadata) Track() int {
+	return s.m.Track
+}
+
+func (s *storedMetadata) Disc() int {
+	return s.m.Disc
+}
+
+func (s *storedMetadata) DiscTotal() int {
+	return s.m.DiscTotal
+}
+
+func (s *storedMetadata) Genre() string {
+	return s.m.Genre
+}
+
+func (s *storedMetadata) Compilation() bool {
+	return s.m.Compilation
+}
+
+func (s *storedMetadata) Modified() time.Time {
+	return s.m.Modified
+}
+
+func (s *storedMetadata) Year() int {
+	return s.m.Year
+}
+
+func (s *storedMetadata) Duration() time.Duration {
+	return s.m.Duration
+}
+
+func (s *storedMetadata) Bitrate() int {
+	return s.m.Bitrate
+}
+
+func (s *storedMetadata) SampleRate() int {
+	return s.m.SampleRate
+}
+
+func (s *storedMetadata) BitDepth() int {
+	return s.m.BitDepth
+}
+
+func (s *storedMetadata) Channels() int {
+	return s.m.Channels
+}
@@ -0,0 +1,216 @@
+package musiclib
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/gob"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestCacheKeepsFS(t *testing.T) {
+	picture := []byte("\x89PNG\r\n\x1a\ncached")
+	fsys := fstest.MapFS{
+		"m/Artist/Album/01.mp3": {Data: id3v23(map[string]string{
+			"TIT2": "Song",
+			"TPE1": "Artist",
+			"TALB": "Album",
+		}, picture)},
+	}
+	roots := []string{"m"}
+
+	ctx := context.Background()
+	scanned, _, err := (&Scanner{FS: fsys}).Scan(ctx, roots, nil)
+	if err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := WriteCache(&buf, roots, scanned); err != nil {
+		t.Fatalf("write cache: %v", err)
+	}
+	files, err := ReadCache(bytes.NewReader(buf.Bytes()), roots, fsys)
+	if err != nil {
+		t.Fatalf("read cache: %v", err)
+	}
+	l, err := IndexFiles(ctx, roots, files)
+	if err != nil {
+		t.Fatalf("index: %v", err)
+	}
+
+	uris, err := l.Media(ctx, encodeFileURI("m"), BrowseOptions{BrowseType: BrowseTypeFile})
+	if err != nil {
+		t.Fatalf("media: %v", err)
+	}
+	if len(uris) != 1 {
+		t.Fatalf("got uris %q, want one", uris)
+	}
+	u, err := url.Parse(uris[0])
+	if err != nil {
+		t.Fatalf("parse %s: %v", uris[0], err)
+	}
+	if u.Host != "" {
+		t.Errorf("got host %q in %s", u.Host, uris[0])
+	}
+
+	track, err := l.Track(ctx, uris[0])
+	if err != nil {
+		t.Fatalf("track: %v", err)
+	}
+	art, err := l.Art(ctx, track.Metadata.AlbumArtURI())
+	if err != nil {
+		t.Fatalf("art: %v", err)
+	}
+	if !bytes.Equal(art.Data, picture) {
+		t.Errorf("got art %q, want %q", art.Data, picture)
+	}
+}
+
+func TestReadCacheErrors(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteCache(&buf, []string{"a"}, &Files{}); err != nil {
+		t.Fatalf("write cache: %v", err)
+	}
+	valid := buf.Bytes()
+
+	tests := []struct {
+		name     string
+		data     []byte
+		roots    []string
+		outdated bool
+	}{
+		{name: "other roots", data: valid, roots: []string{"b"}, outdated: true},
+		{name: "not gzip", data: []byte("not a cache"), roots: []string{"a"}},
+		{name: "truncated", data: valid[:len(valid)/2], roots: []string{"a"}},
+		{name: "empty", roots: []string{"a"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ReadCache(bytes.NewReader(tc.data), tc.roots, nil)
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if got := errors.Is(err, ErrCacheOutdated); got != tc.outdated {
+				t.Errorf("got error %v, want outdated %v", err, tc.outdated)
+			}
+		})
+	}
+}
+
+func TestLoadCacheThenLoad(t *testing.T) {
+	mapFS := fstest.MapFS{
+		"lib/A/1.mp3": titledSong("One"),
+		"lib/A/2.mp3": titledSong("Two"),
+		"lib/A/3.mp3": titledSong("Three"),
+	}
+	fsys := newCountingFS(mapFS)
+	roots := []string{"lib"}
+	cachePath := filepath.Join(t.TempDir(), "musiclib", "scan.cache")
+	ctx := context.Background()
+
+	scanned := NewReloadableLibrary(roots, &Scanner{FS: fsys}, nil)
+	if _, err := scanned.Load(ctx); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if err := scanned.SaveCache(cachePath); err != nil {
+		t.Fatalf("save cache: %v", err)
+	}
+	want, err := scanned.Media(ctx, encodeFileURI("lib"), BrowseOptions{BrowseType: BrowseTypeFile})
+	if err != nil {
+		t.Fatalf("media: %v", err)
+	}
+
+	mapFS["lib/A/2.mp3"].ModTime = mapFS["lib/A/2.mp3"].ModTime.Add(time.Hour)
+	fsys.reset()
+
+	cached := NewReloadableLibrary(roots, &Scanner{FS: fsys}, nil)
+	if err := cached.LoadCache(ctx, cachePath); err != nil {
+		t.Fatalf("load cache: %v", err)
+	}
+	if len(fsys.opened) != 0 {
+		t.Errorf("loading the cache opened %v", fsys.opened)
+	}
+	got, err := cached.Media(ctx, encodeFileURI("lib"), BrowseOptions{BrowseType: BrowseTypeFile})
+	if err != nil {
+		t.Fatalf("media: %v", err)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got cached media %q, want %q", got, want)
+	}
+
+	report, err := cached.Load(ctx)
+	if err != nil {
+		t.Fatalf("load after cache: %v", err)
+	}
+	if report.Reused != 2 || report.Changed != 1 || report.Added != 0 || report.Removed != 0 {
+		t.Errorf("got report %+v, want 2 reused and 1 changed", report)
+	}
+	for _, name := range []string{"lib/A/1.mp3", "lib/A/2.mp3", "lib/A/3.mp3"} {
+		wantOpened := 0
+		if name == "lib/A/2.mp3" {
+			wantOpened = 1
+		}
+		if got := fsys.opened[name]; got != wantOpened {
+			t.Errorf("%s opened %d times, want %d", name, got, wantOpened)
+		}
+	}
+}
+
+func TestCacheVersion(t *testing.T) {
+	writeHeader := func(t *testing.T, version int) []byte {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		enc := gob.NewEncoder(zw)
+		if err := enc.Encode(cacheHeader{Version: version, RootPaths: []string{"lib"}}); err != nil {
+			t.Fatalf("encode header: %v", err)
+		}
+		if err := enc.Encode([]cachedPath{}); err != nil {
+			t.Fatalf("encode roots: %v", err)
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatalf("close: %v", err)
+		}
+		return buf.Bytes()
+	}
+
+	tests := []struct {
+		name     string
+		version  int
+		outdated bool
+	}{
+		{name: "current", version: cacheVersion},
+		{name: "older", version: cacheVersion - 1, outdated: true},
+		{name: "newer", version: cacheVersion + 1, outdated: true},
+		{name: "unversioned", version: 0, outdated: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			data := writeHeader(t, tc.version)
+			_, err := ReadCache(bytes.NewReader(data), []string{"lib"}, nil)
+			if got := errors.Is(err, ErrCacheOutdated); got != tc.outdated {
+				t.Errorf("got error %v, want outdated %v", err, tc.outdated)
+			}
+			if !tc.outdated && err != nil {
+				t.Fatalf("read cache: %v", err)
+			}
+
+			cachePath := filepath.Join(t.TempDir(), "scan.cache")
+			if err := os.WriteFile(cachePath, data, 0o644); err != nil {
+				t.Fatalf("write cache: %v", err)
+			}
+			err = NewReloadableLibrary([]string{"lib"}, &Scanner{FS: fstest.MapFS{}}, nil).LoadCache(context.Background(), cachePath)
+			if got := err != nil && strings.Contains(err.Error(), ErrCacheOutdated.Error()); got != tc.outdated {
+				t.Errorf("got LoadCache error %v, want outdated %v", err, tc.outdated)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/mctofu/musiclib"
+)
+
+type artLibrary interface {
+	Art(ctx context.Context, uri string) (*musiclib.Art, error)
+}
+
+// artHandler serves the embedded art identified by the uri query parameter
+// so clients can fetch the images behind art:// ImageURIs.
+func artHandler(library artLibrary) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		uri := r.URL.Query().Get("uri")
+		art, err := library.Art(r.Context(), uri)
+		if err != nil {
+			if errors.Is(err, musiclib.ErrArtNotFound) {
+				http.NotFound(w, r)
+				return
+			}
+			log.Printf("Failed to load art %s: %v\n", uri, err)
+			http.Error(w, "failed to load art", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", art.MIMEType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(art.Data)))
+		// art URIs are derived from the image content so never change
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		if _, err := w.Write(art.Data); err != nil {
+			log.Printf("Failed to write art %s: %v\n", uri, err)
+		}
+	})
+}
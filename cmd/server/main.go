@@ -5,9 +5,11 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"path"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -17,6 +19,13 @@ import (
 	"google.golang.org/grpc"
 )
 
+// watchDebounce is how long to wait for changes to settle before reloading.
+const watchDebounce = 5 * time.Second
+
+// watchMaxWait is the longest a reload waits for changes that keep coming,
+// such as during a long copy.
+const watchMaxWait = 2 * time.Minute
+
 func main() {
 	if err := run(); err != nil {
 		log.Fatalf("Error occurred: %v\n", err)
@@ -27,11 +36,20 @@ func run() error {
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer stop()
 
-	reload := make(chan os.Signal)
+	reload := make(chan os.Signal, 1)
 	signal.Notify(reload, syscall.SIGHUP)
 
 	rootPathSetting, _ := os.LookupEnv("MUSICLIB_ROOT_PATHS")
 	listenAddrSetting, _ := os.LookupEnv("MUSICLIB_LISTEN_ADDR")
+	cachePathSetting, _ := os.LookupEnv("MUSICLIB_CACHE_PATH")
+	watchSetting, _ := os.LookupEnv("MUSICLIB_WATCH")
+	scanWorkersSetting, _ := os.LookupEnv("MUSICLIB_SCAN_WORKERS")
+	skipErrorsSetting, _ := os.LookupEnv("MUSICLIB_SCAN_SKIP_ERRORS")
+	sniffSetting, _ := os.LookupEnv("MUSICLIB_SCAN_SNIFF")
+	artListenAddr, _ := os.LookupEnv("MUSICLIB_ART_LISTEN_ADDR")
+	variousArtists, _ := os.LookupEnv("MUSICLIB_VARIOUS_ARTISTS")
+	articlesSetting, articlesSet := os.LookupEnv("MUSICLIB_SORT_ARTICLES")
+	keepFileOrderSetting, _ := os.LookupEnv("MUSICLIB_KEEP_FILE_ORDER")
 
 	var rootPaths []string
 	if rootPathSetting == "" {
@@ -49,17 +67,135 @@ func run() error {
 		listenAddr = "127.0.0.1:8337"
 	}
 
+	cachePath := cachePathSetting
+	if cachePath == "" {
+		cacheDir, err := os.UserCacheDir()
+		if err != nil {
+			log.Printf("MUSICLIB_CACHE_PATH not set and could not detect cache dir, not caching: %v\n", err)
+		} else {
+			cachePath = path.Join(cacheDir, "musiclib", "scan.cache")
+		}
+	}
+
+	watch := false
+	if watchSetting != "" {
+		var err error
+		watch, err = strconv.ParseBool(watchSetting)
+		if err != nil {
+			return fmt.Errorf("invalid MUSICLIB_WATCH: %v", err)
+		}
+	}
+
+	scanner := &musiclib.Scanner{
+		Workers: 4,
+	}
+	if scanWorkersSetting != "" {
+		workers, err := strconv.Atoi(scanWorkersSetting)
+		if err != nil {
+			return fmt.Errorf("invalid MUSICLIB_SCAN_WORKERS: %v", err)
+		}
+		scanner.Workers = workers
+	}
+	if skipErrorsSetting != "" {
+		skipErrors, err := strconv.ParseBool(skipErrorsSetting)
+		if err != nil {
+			return fmt.Errorf("invalid MUSICLIB_SCAN_SKIP_ERRORS: %v", err)
+		}
+		scanner.SkipErrors = skipErrors
+	}
+	if sniffSetting != "" {
+		sniff, err := strconv.ParseBool(sniffSetting)
+		if err != nil {
+			return fmt.Errorf("invalid MUSICLIB_SCAN_SNIFF: %v", err)
+		}
+		scanner.SniffContent = sniff
+	}
+
+	indexer := &musiclib.Indexer{
+		VariousArtists: variousArtists,
+	}
+	if articlesSet {
+		// an empty setting disables article stripping
+		indexer.Articles = []string{}
+		for _, article := range strings.Split(articlesSetting, ",") {
+			if article = strings.TrimSpace(article); article != "" {
+				indexer.Articles = append(indexer.Articles, article)
+			}
+		}
+	}
+	if keepFileOrderSetting != "" {
+		indexer.KeepFileOrder = make(map[musiclib.BrowseType]bool)
+		for _, setting := range strings.Split(keepFileOrderSetting, ",") {
+			browseType := musiclib.BrowseType(strings.TrimSpace(setting))
+			switch browseType {
+			case musiclib.BrowseTypeAlbumArtist, musiclib.BrowseTypeGenre, musiclib.BrowseTypeYear, musiclib.BrowseTypeModified:
+				indexer.KeepFileOrder[browseType] = true
+			default:
+				return fmt.Errorf("invalid MUSICLIB_KEEP_FILE_ORDER browse type: %q", setting)
+			}
+		}
+	}
+
 	lis, err := net.Listen("tcp", listenAddr)
 	if err != nil {
 		return fmt.Errorf("failed to listen: %v", err)
 	}
 
-	log.Println("Loading library")
-	library := musiclib.NewReloadableLibrary(rootPaths)
-	if err := library.Load(ctx); err != nil {
-		return fmt.Errorf("failed to init library: %v", err)
+	library := musiclib.NewReloadableLibrary(rootPaths, scanner, indexer)
+
+	cacheLoaded := false
+	if cachePath != "" {
+		log.Println("Loading library from cache")
+		if err := library.LoadCache(ctx, cachePath); err != nil {
+			log.Printf("Could not use cache, rebuilding: %v\n", err)
+		} else {
+			cacheLoaded = true
+			log.Println("Loaded library from cache")
+		}
+	}
+
+	if cacheLoaded {
+		// serve from the cache right away and catch up with the filesystem
+		go reloadLibrary(ctx, library, cachePath)
+	} else {
+		log.Println("Loading library")
+		report, err := library.Load(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to init library: %v", err)
+		}
+		log.Println("Loaded library")
+		logScanErrors(report)
+		saveCache(library, cachePath)
+	}
+
+	if watch {
+		go func() {
+			log.Println("Watching root paths for changes")
+			err := musiclib.WatchRoots(ctx, rootPaths, watchDebounce, watchMaxWait, func(changedPaths []string) {
+				log.Printf("Detected %d changed paths\n", len(changedPaths))
+				reloadLibrary(ctx, library, cachePath)
+			})
+			if err != nil && ctx.Err() == nil {
+				log.Printf("Stopped watching root paths: %v\n", err)
+			}
+		}()
+	}
+
+	var artServer *http.Server
+	if artListenAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/art", artHandler(library))
+		artServer = &http.Server{
+			Addr:    artListenAddr,
+			Handler: mux,
+		}
+		go func() {
+			log.Println("Starting art server")
+			if err := artServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Printf("Art server failed: %v\n", err)
+			}
+		}()
 	}
-	log.Println("Loaded library")
 
 	s := grpc.NewServer()
 	mlibgrpc.RegisterMusicLibraryServer(s,
@@ -71,14 +207,12 @@ func run() error {
 		for {
 			select {
 			case <-reload:
-				log.Println("Reloading library")
-				if err := library.Load(ctx); err != nil {
-					log.Printf("Failed to reload library: %v\n", err)
-				} else {
-					log.Println("Reloaded library")
-				}
+				reloadLibrary(ctx, library, cachePath)
 			case <-ctx.Done():
 				log.Println("Stopping")
+				if artServer != nil {
+					artServer.Close()
+				}
 				s.GracefulStop()
 				log.Println("Stopped")
 				return
@@ -94,6 +228,37 @@ func run() error {
 	return nil
 }
 
+func reloadLibrary(ctx context.Context, library *musiclib.ReloadableLibrary, cachePath string) {
+	log.Println("Reloading library")
+	report, err := library.Load(ctx)
+	if err != nil {
+		log.Printf("Failed to reload library: %v\n", err)
+		return
+	}
+	log.Printf("Reloaded library: %d added, %d changed, %d removed, %d reused\n",
+		report.Added, report.Changed, report.Removed, report.Reused)
+	logScanErrors(report)
+
+	if report.Added > 0 || report.Changed > 0 || report.Removed > 0 {
+		saveCache(library, cachePath)
+	}
+}
+
+func logScanErrors(report *musiclib.ScanReport) {
+	for _, scanErr := range report.Errors {
+		log.Printf("Scan error: %v\n", &scanErr)
+	}
+}
+
+func saveCache(library *musiclib.ReloadableLibrary, cachePath string) {
+	if cachePath == "" {
+		return
+	}
+	if err := library.SaveCache(cachePath); err != nil {
+		log.Printf("Failed to save cache: %v\n", err)
+	}
+}
+
 type library interface {
 	Browse(ctx context.Context, browseURI string, opts musiclib.BrowseOptions) ([]*musiclib.BrowseItem, error)
 	Media(ctx context.Context, uri string, opts musiclib.BrowseOptions) ([]string, error)
@@ -113,10 +278,7 @@ func (s *server) Browse(ctx context.Context, in *mlibgrpc.BrowseRequest) (*mlibg
 		return nil, err
 	}
 
-	browseOpts := musiclib.BrowseOptions{
-		TextFilter: strings.ToLower(in.GetSearch()),
-		BrowseType: browseType,
-	}
+	browseOpts := toBrowseOptions(in.GetSearch(), browseType)
 
 	items, err := s.library.Browse(ctx, in.GetUri(), browseOpts)
 	if err != nil {
@@ -145,10 +307,7 @@ func (s *server) Media(ctx context.Context, in *mlibgrpc.MediaRequest) (*mlibgrp
 		return nil, err
 	}
 
-	browseOpts := musiclib.BrowseOptions{
-		TextFilter: strings.ToLower(in.GetSearch()),
-		BrowseType: browseType,
-	}
+	browseOpts := toBrowseOptions(in.GetSearch(), browseType)
 
 	uris, err := s.library.Media(ctx, in.GetUri(), browseOpts)
 	if err != nil {
@@ -168,6 +327,26 @@ func (s *server) Media(ctx context.Context, in *mlibgrpc.MediaRequest) (*mlibgrp
 	}, nil
 }
 
+// fuzzySearchPrefix marks a search as fuzzy.
+const fuzzySearchPrefix = "~"
+
+// toBrowseOptions searches fuzzily when the search text starts with
+// fuzzySearchPrefix, with a query when it uses query syntax and by name
+// otherwise.
+func toBrowseOptions(search string, browseType musiclib.BrowseType) musiclib.BrowseOptions {
+	opts := musiclib.BrowseOptions{
+		TextFilter: search,
+		BrowseType: browseType,
+	}
+	if fuzzySearch, ok := strings.CutPrefix(search, fuzzySearchPrefix); ok {
+		opts.TextFilter = fuzzySearch
+		opts.SearchMode = musiclib.SearchModeFuzzy
+	} else if musiclib.LooksLikeQuery(search) {
+		opts.SearchMode = musiclib.SearchModeQuery
+	}
+	return opts
+}
+
 func toMusicLibBrowseType(t mlibgrpc.BrowseType) (musiclib.BrowseType, error) {
 	switch t {
 	case mlibgrpc.BrowseType_BROWSE_TYPE_ALBUM_ARTIST:
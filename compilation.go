@@ -0,0 +1,89 @@
+package musiclib
+
+// DefaultVariousArtists is the artist compilations are grouped under.
+const DefaultVariousArtists = "Various Artists"
+
+// compilationMinArtists is the fewest different track artists an album
+// without a compilation tag needs to be detected as a compilation.
+const compilationMinArtists = 3
+
+// albumKey identifies an album within a directory.
+type albumKey struct {
+	dir   string
+	album string
+}
+
+// findCompilations returns the albums in each directory that are tagged as
+// compilations or that have no album artist and tracks by many different
+// artists.
+func findCompilations(files *Files) (map[albumKey]bool, error) {
+	type albumStats struct {
+		tracks      int
+		tagged      bool
+		albumArtist bool
+		artists     map[string]bool
+	}
+
+	albums := make(map[albumKey]*albumStats)
+	if err := files.WalkFiles(func(dir *PathMeta, file *PathMeta) error {
+		if file.Metadata == nil {
+			return nil
+		}
+
+		key := albumKey{dir: dir.Path, album: file.Metadata.Album()}
+		stats, ok := albums[key]
+		if !ok {
+			stats = &albumStats{artists: make(map[string]bool)}
+			albums[key] = stats
+		}
+		stats.tracks++
+		stats.tagged = stats.tagged || file.Metadata.Compilation()
+		// album artist falls back to the track artist when it isn't tagged
+		stats.albumArtist = stats.albumArtist || file.Metadata.AlbumArtist() != file.Metadata.Artist()
+		stats.artists[file.Metadata.Artist()] = true
+
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	compilations := make(map[albumKey]bool)
+	for key, stats := range albums {
+		manyArtists := len(stats.artists) >= compilationMinArtists && len(stats.artists)*2 > stats.tracks
+		if stats.tagged || (!stats.albumArtist && manyArtists) {
+			compilations[key] = true
+		}
+	}
+
+	return compilations, nil
+}
+
+// asCompilation returns a copy of file whose album artist is variousArtists.
+func asCompilation(file *PathMeta, variousArtists string) *PathMeta {
+	compilation := *file
+	compilation.folded = nil
+	compilation.Metadata = &compilationMetadata{
+		MediaMetadata: file.Metadata,
+		albumArtist:   variousArtists,
+	}
+	return &compilation
+}
+
+type compilationMetadata struct {
+	MediaMetadata
+	albumArtist string
+}
+
+func (c *compilationMetadata) AlbumArtist() string {
+	return c.albumArtist
+}
+
+// AlbumArtistSort is empty as the track artists' sort tags don't apply to
+// the compilation's artist.
+func (c *compilationMetadata) AlbumArtistSort() string {
+	return ""
+}
+
+func (c *compilationMetadata) Compilation() bool {
+	return true
+}
@@ -2,12 +2,12 @@ package musiclib
 
 import (
 	"context"
-	"strings"
 )
 
 type FileIndex struct {
 	uriLookup map[string]*Node
 	roots     []*Node
+	text      textIndex
 }
 
 func (f *FileIndex) Roots(ctx context.Context) ([]*Node, error) {
@@ -18,30 +18,62 @@ func (f *FileIndex) Node(ctx context.Context, uri string) (*Node, error) {
 	return f.uriLookup[uri], nil
 }
 
+func (f *FileIndex) searchText(filter string) map[*Node]bool {
+	return f.text.search(filter)
+}
+
+func (f *FileIndex) searchSimilar(text string) []*Node {
+	return f.text.similar(text)
+}
+
 func (f *FileIndex) Index(ctx context.Context, files *Files) error {
 	if f.uriLookup == nil {
 		f.uriLookup = make(map[string]*Node)
 	}
 
 	for _, root := range files.Roots {
-		rootNode := f.addNode(nil, &root)
+		rootNode := f.addNode(nil, nil, &root)
+		computeStats(rootNode)
 		f.roots = append(f.roots, rootNode)
 	}
 
 	return nil
 }
 
-func (f *FileIndex) addNode(parent *Node, filePath *PathMeta) *Node {
+func (f *FileIndex) addNode(parent *Node, dir *PathMeta, filePath *PathMeta) *Node {
 	node := &Node{
 		Name:      filePath.Name,
-		LowerName: strings.ToLower(filePath.Name),
+		LowerName: foldText(filePath.Name),
 		URI:       encodeFileURI(filePath.Path),
-		ImageURI:  encodeFileURI(filePath.ImagePath),
 		Parent:    parent,
 	}
+	if filePath.IsDir() {
+		if !filePath.ImageInherited {
+			node.ImageURI = encodeFileURI(filePath.ImagePath)
+		}
+	} else {
+		node.file = filePath
+		filePath.foldMetadata()
+		node.ImageURI = fileArtURI(dir, filePath)
+	}
+
+	for i := range filePath.Children {
+		f.addNode(node, filePath, &filePath.Children[i])
+	}
 
-	for _, child := range filePath.Children {
-		f.addNode(node, &child)
+	// directories without an image use the first art of their contents
+	// before art inherited from a parent directory
+	inherited := inheritedArtURI(filePath)
+	for _, child := range node.Children {
+		if node.ImageURI != "" {
+			break
+		}
+		if child.ImageURI != inherited {
+			node.ImageURI = child.ImageURI
+		}
+	}
+	if node.ImageURI == "" {
+		node.ImageURI = inherited
 	}
 
 	if parent != nil {
@@ -49,6 +81,7 @@ func (f *FileIndex) addNode(parent *Node, filePath *PathMeta) *Node {
 	}
 
 	f.uriLookup[node.URI] = node
+	f.text.add(node)
 
 	return node
 }
@@ -0,0 +1,22 @@
+package musiclib
+
+import (
+	"unicode"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// foldText normalizes s for text matching. Compatibility characters are
+// decomposed, diacritics removed and case folded so "Björk", "bjork" and
+// "ＢＪＯＲＫ" are equal.
+func foldText(s string) string {
+	t := transform.Chain(norm.NFKD, runes.Remove(runes.In(unicode.Mn)), cases.Fold())
+	folded, _, err := transform.String(t, s)
+	if err != nil {
+		return s
+	}
+	return folded
+}
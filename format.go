@@ -0,0 +1,393 @@
+package musiclib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/dhowden/tag"
+)
+
+// TagReader reads tags from the start of a media file.
+type TagReader func(r io.ReadSeeker) (tag.Metadata, error)
+
+// InfoReader reads audio stream info from a media file of the given size.
+type InfoReader func(r io.ReadSeeker, size int64) (AudioInfo, error)
+
+// Signature identifies a format by the bytes found at Offset in a file. If
+// Mask is set it is applied to the file's bytes before comparing. If Check is
+// set it must also accept the file's bytes.
+type Signature struct {
+	Offset int
+	Bytes  []byte
+	Mask   []byte
+	Check  func(b []byte) bool
+}
+
+// Format describes a media file format the scanner can read.
+type Format struct {
+	Name string
+	// Extensions are matched case-insensitively against file names,
+	// including the leading dot.
+	Extensions []string
+	// Signatures identify the format from a file's leading bytes.
+	Signatures []Signature
+	ReadTags   TagReader
+	// ReadInfo is optional. Without it stream info is left unknown.
+	ReadInfo InfoReader
+}
+
+// imgSignatures identify images when sniffing file content.
+var imgSignatures = []Signature{
+	{Bytes: []byte("\x89PNG\r\n\x1a\n")},
+	{Bytes: []byte{0xff, 0xd8, 0xff}},
+	{Bytes: []byte("GIF8")},
+}
+
+var formatRegistry = struct {
+	sync.RWMutex
+	formats []*Format
+	byExt   map[string]*Format
+}{
+	byExt: make(map[string]*Format),
+}
+
+// RegisterFormat adds a format to be scanned. A format registered later
+// replaces earlier formats with the same extensions.
+func RegisterFormat(f *Format) {
+	formatRegistry.Lock()
+	defer formatRegistry.Unlock()
+
+	formatRegistry.formats = append(formatRegistry.formats, f)
+	for _, ext := range f.Extensions {
+		formatRegistry.byExt[strings.ToLower(ext)] = f
+	}
+}
+
+func formatForExt(ext string) *Format {
+	formatRegistry.RLock()
+	defer formatRegistry.RUnlock()
+	return formatRegistry.byExt[ext]
+}
+
+// formatForHeader returns the format whose signature matches the leading
+// bytes of a file, preferring formats registered later.
+func formatForHeader(header []byte) *Format {
+	formatRegistry.RLock()
+	defer formatRegistry.RUnlock()
+
+	for i := len(formatRegistry.formats) - 1; i >= 0; i-- {
+		f := formatRegistry.formats[i]
+		for _, sig := range f.Signatures {
+			if matchSignature(header, sig) {
+				return f
+			}
+		}
+	}
+	return nil
+}
+
+// sniffLen returns how many leading bytes are needed to match any signature.
+func sniffLen() int {
+	formatRegistry.RLock()
+	defer formatRegistry.RUnlock()
+
+	n := 0
+	for _, sig := range imgSignatures {
+		n = max(n, sig.Offset+len(sig.Bytes))
+	}
+	for _, f := range formatRegistry.formats {
+		for _, sig := range f.Signatures {
+			n = max(n, sig.Offset+len(sig.Bytes))
+		}
+	}
+	return n
+}
+
+func matchSignature(header []byte, sig Signature) bool {
+	end := sig.Offset + len(sig.Bytes)
+	if len(header) < end {
+		return false
+	}
+	data := header[sig.Offset:end]
+	if sig.Mask == nil {
+		if !bytes.Equal(data, sig.Bytes) {
+			return false
+		}
+	} else {
+		for i := range sig.Bytes {
+			if data[i]&sig.Mask[i] != sig.Bytes[i] {
+				return false
+			}
+		}
+	}
+	return sig.Check == nil || sig.Check(data)
+}
+
+func init() {
+	// tag.ReadFrom detects ID3, FLAC, Ogg, MP4 and DSF itself
+	for _, f := range []*Format{
+		{
+			Name:       "FLAC",
+			Extensions: []string{".flac"},
+			Signatures: []Signature{{Bytes: []byte("fLaC")}},
+			ReadTags:   tag.ReadFrom,
+			ReadInfo:   readFLACInfo,
+		},
+		{
+			Name:       "MP3",
+			Extensions: []string{".mp3"},
+			Signatures: []Signature{
+				{Bytes: []byte("ID3")},
+				// MPEG audio frame sync. Text starting with a UTF-16LE byte
+				// order mark also has the sync bits set, so the rest of the
+				// header must be valid too.
+				{
+					Bytes: []byte{0xff, 0xe0, 0, 0},
+					Mask:  []byte{0xff, 0xe0, 0, 0},
+					Check: isMP3FrameHeader,
+				},
+			},
+			ReadTags: tag.ReadFrom,
+			ReadInfo: readMP3Info,
+		},
+		{
+			Name:       "MP4",
+			Extensions: []string{".m4a", ".m4b", ".mp4", ".alac"},
+			Signatures: []Signature{{Offset: 4, Bytes: []byte("ftyp")}},
+			ReadTags:   tag.ReadFrom,
+			ReadInfo:   readMP4Info,
+		},
+		{
+			Name:       "Ogg",
+			Extensions: []string{".ogg", ".oga", ".opus"},
+			Signatures: []Signature{{Bytes: []byte("OggS")}},
+			ReadTags:   tag.ReadFrom,
+			ReadInfo:   readOggInfo,
+		},
+		{
+			Name:       "WAV",
+			Extensions: []string{".wav"},
+			Signatures: []Signature{{Offset: 8, Bytes: []byte("WAVE")}},
+			ReadTags:   readRIFFTags,
+			ReadInfo:   readWAVInfo,
+		},
+		{
+			Name:       "AIFF",
+			Extensions: []string{".aif", ".aiff", ".aifc"},
+			Signatures: []Signature{
+				{Offset: 8, Bytes: []byte("AIFF")},
+				{Offset: 8, Bytes: []byte("AIFC")},
+			},
+			ReadTags: readAIFFTags,
+			ReadInfo: readAIFFInfo,
+		},
+		{
+			Name:       "DSF",
+			Extensions: []string{".dsf"},
+			Signatures: []Signature{{Bytes: []byte("DSD ")}},
+			ReadTags:   tag.ReadFrom,
+		},
+		{
+			Name:       "DSDIFF",
+			Extensions: []string{".dff"},
+			Signatures: []Signature{{Bytes: []byte("FRM8")}},
+			ReadTags:   readDSDIFFTags,
+		},
+		{
+			Name:       "WavPack",
+			Extensions: []string{".wv"},
+			Signatures: []Signature{{Bytes: []byte("wvpk")}},
+			ReadTags:   readAPETags,
+		},
+		{
+			Name:       "Monkey's Audio",
+			Extensions: []string{".ape"},
+			Signatures: []Signature{{Bytes: []byte("MAC ")}},
+			ReadTags:   readAPETags,
+		},
+		{
+			Name:       "Musepack",
+			Extensions: []string{".mpc"},
+			Signatures: []Signature{
+				{Bytes: []byte("MPCK")},
+				{Bytes: []byte("MP+")},
+			},
+			ReadTags: readAPETags,
+		},
+	} {
+		RegisterFormat(f)
+	}
+}
+
+func readRIFFTags(r io.ReadSeeker) (tag.Metadata, error) {
+	return readChunkedID3(r, 12, 4, binary.LittleEndian)
+}
+
+func readAIFFTags(r io.ReadSeeker) (tag.Metadata, error) {
+	return readChunkedID3(r, 12, 4, binary.BigEndian)
+}
+
+func readDSDIFFTags(r io.ReadSeeker) (tag.Metadata, error) {
+	return readChunkedID3(r, 16, 8, binary.BigEndian)
+}
+
+// readChunkedID3 reads tags from the ID3 chunk of an IFF style container
+// whose chunks start after headerSize bytes and have sizes of sizeLen bytes.
+func readChunkedID3(r io.ReadSeeker, headerSize int64, sizeLen int, order binary.ByteOrder) (tag.Metadata, error) {
+	if _, err := r.Seek(headerSize, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 4+sizeLen)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil, tag.ErrNoTagsFound
+			}
+			return nil, err
+		}
+
+		var size int64
+		if sizeLen == 8 {
+			size = int64(order.Uint64(header[4:]))
+		} else {
+			size = int64(order.Uint32(header[4:]))
+		}
+		if size < 0 {
+			return nil, errors.New("invalid chunk size")
+		}
+
+		switch string(header[:4]) {
+		case "ID3 ", "id3 ":
+			return tag.ReadID3v2Tags(r)
+		}
+
+		// chunks are padded to an even size
+		if _, err := r.Seek(size+size%2, io.SeekCurrent); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// filenameMetadata derives tags from a file's name and directory for files
+// without readable tags.
+type filenameMetadata struct {
+	title string
+	album string
+	track int
+}
+
+func newFilenameMetadata(fileName string, dirName string) *filenameMetadata {
+	name := strings.TrimSuffix(fileName, path.Ext(fileName))
+
+	// names like "03 - Title" or "03. Title"
+	m := &filenameMetadata{title: name}
+	digits := strings.IndexFunc(name, func(r rune) bool { return !unicode.IsDigit(r) })
+	if digits > 0 {
+		title := strings.TrimLeft(name[digits:], " -._")
+		if track, err := strconv.Atoi(name[:digits]); err == nil && title != "" {
+			m.track = track
+			m.title = title
+		}
+	}
+
+	m.album = dirName
+
+	return m
+}
+
+func (m *filenameMetadata) Format() tag.Format {
+	return tag.UnknownFormat
+}
+
+func (m *filenameMetadata) FileType() tag.FileType {
+	return tag.UnknownFileType
+}
+
+func (m *filenameMetadata) Title() string {
+	return m.title
+}
+
+func (m *filenameMetadata) Album() string {
+	return m.album
+}
+
+func (m *filenameMetadata) Artist() string {
+	return ""
+}
+
+func (m *filenameMetadata) AlbumArtist() string {
+	return ""
+}
+
+func (m *filenameMetadata) Composer() string {
+	return ""
+}
+
+func (m *filenameMetadata) Year() int {
+	return 0
+}
+
+func (m *filenameMetadata) Genre() string {
+	return ""
+}
+
+func (m *filenameMetadata) Track() (int, int) {
+	return m.track, 0
+}
+
+func (m *filenameMetadata) Disc() (int, int) {
+	return 0, 0
+}
+
+func (m *filenameMetadata) Picture() *tag.Picture {
+	return nil
+}
+
+func (m *filenameMetadata) Lyrics() string {
+	return ""
+}
+
+func (m *filenameMetadata) Comment() string {
+	return ""
+}
+
+func (m *filenameMetadata) Raw() map[string]interface{} {
+	return map[string]interface{}{}
+}
+
+// filenameFallbackMetadata fills the title, album and track that tags leave
+// empty from a file's name and directory.
+type filenameFallbackMetadata struct {
+	tag.Metadata
+	filename *filenameMetadata
+}
+
+func (m *filenameFallbackMetadata) Title() string {
+	if title := m.Metadata.Title(); title != "" {
+		return title
+	}
+	return m.filename.Title()
+}
+
+func (m *filenameFallbackMetadata) Album() string {
+	if album := m.Metadata.Album(); album != "" {
+		return album
+	}
+	return m.filename.Album()
+}
+
+func (m *filenameFallbackMetadata) Track() (int, int) {
+	track, total := m.Metadata.Track()
+	if track == 0 {
+		track, _ = m.filename.Track()
+	}
+	return track, total
+}
@@ -0,0 +1,188 @@
+package musiclib
+
+import (
+	"sort"
+	"strings"
+)
+
+const (
+	// fuzzyMinScore is the lowest similarity a fuzzy match may have.
+	fuzzyMinScore = 0.5
+	// fuzzyMaxResults limits how many fuzzy matches are returned.
+	fuzzyMaxResults = 25
+	// fuzzyPartialWeight scales the score of text matching only some of the
+	// words of a name so whole name matches rank first.
+	fuzzyPartialWeight = 0.8
+)
+
+type fuzzyMatch struct {
+	node  *Node
+	score float64
+}
+
+// fuzzySearch returns the nodes in and below scope whose names are most
+// similar to text, best first.
+func fuzzySearch(index Index, scope []*Node, text string) []fuzzyMatch {
+	text = foldText(text)
+	if text == "" {
+		return nil
+	}
+	textTrigrams := trigramSet(text)
+	textWords := len(strings.Fields(text))
+
+	var candidates []*Node
+	if searcher, ok := index.(textSearcher); ok {
+		inScope := make(map[*Node]bool, len(scope))
+		for _, n := range scope {
+			inScope[n] = true
+		}
+		for _, n := range searcher.searchSimilar(text) {
+			for p := n; p != nil; p = p.Parent {
+				if inScope[p] {
+					candidates = append(candidates, n)
+					break
+				}
+			}
+		}
+	}
+	// without an index, or when text shares no trigram with any name in
+	// scope as with "axc" for "abc", everything in scope is scored
+	if len(candidates) == 0 {
+		var walk func(nodes []*Node)
+		walk = func(nodes []*Node) {
+			for _, n := range nodes {
+				candidates = append(candidates, n)
+				walk(n.Children)
+			}
+		}
+		walk(scope)
+	}
+
+	var matches []fuzzyMatch
+	for _, n := range candidates {
+		score := fuzzyScore(text, textTrigrams, textWords, n.LowerName)
+		if score >= fuzzyMinScore {
+			matches = append(matches, fuzzyMatch{node: n, score: score})
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].score > matches[j].score
+	})
+	if len(matches) > fuzzyMaxResults {
+		matches = matches[:fuzzyMaxResults]
+	}
+
+	return matches
+}
+
+// fuzzyScore rates how similar name is to text from 0 to 1. Runs of words in
+// name as long as text are compared too, scaled by fuzzyPartialWeight.
+func fuzzyScore(text string, textTrigrams map[trigram]bool, textWords int, name string) float64 {
+	score := similarity(text, textTrigrams, name)
+
+	words := strings.Fields(name)
+	if textWords < len(words) {
+		for i := 0; i+textWords <= len(words); i++ {
+			partial := strings.Join(words[i:i+textWords], " ")
+			score = max(score, similarity(text, textTrigrams, partial)*fuzzyPartialWeight)
+		}
+	}
+
+	return score
+}
+
+// similarity is the better of the trigram and edit similarity of text and
+// name.
+func similarity(text string, textTrigrams map[trigram]bool, name string) float64 {
+	return max(trigramSimilarity(textTrigrams, trigramSet(name)), editSimilarity(text, name))
+}
+
+func fuzzyBrowseItems(matches []fuzzyMatch, order *nodeOrder) []*BrowseItem {
+	var results []*BrowseItem
+	for _, match := range order.sortMatches(matches) {
+		item := toBrowseItem(match.node)
+		item.Score = match.score
+		results = append(results, item)
+	}
+	return results
+}
+
+// walkFuzzyLeaves calls walkFn with the songs below the matches, best match
+// first unless ordered otherwise, skipping songs below more than one match.
+func walkFuzzyLeaves(matches []fuzzyMatch, order *nodeOrder, walkFn WalkNodeFunc) error {
+	seen := make(map[string]bool)
+	for _, match := range order.sortMatches(matches) {
+		if err := order.walkLeaves(match.node, func(n *Node) error {
+			if seen[n.URI] {
+				return nil
+			}
+			seen[n.URI] = true
+			return walkFn(n)
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// trigramSet returns the trigrams of s padded with spaces so that short
+// strings and word boundaries are represented.
+func trigramSet(s string) map[trigram]bool {
+	s = " " + s + " "
+	set := make(map[trigram]bool, len(s))
+	for i := 0; i+3 <= len(s); i++ {
+		set[trigram{s[i], s[i+1], s[i+2]}] = true
+	}
+	return set
+}
+
+// trigramSimilarity is the share of trigrams in a or b that are in both.
+func trigramSimilarity(a map[trigram]bool, b map[trigram]bool) float64 {
+	shared := 0
+	for t := range a {
+		if b[t] {
+			shared++
+		}
+	}
+	total := len(a) + len(b) - shared
+	if total == 0 {
+		return 0
+	}
+	return float64(shared) / float64(total)
+}
+
+// editSimilarity is 1 less the edit distance between a and b relative to the
+// longer of them. Substitutions, insertions, deletions and transpositions of
+// adjacent characters count as one edit.
+func editSimilarity(a string, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	longest := max(len(ra), len(rb))
+	if longest == 0 {
+		return 0
+	}
+
+	// rows of the optimal string alignment distance matrix
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+
+	return 1 - float64(prev[len(rb)])/float64(longest)
+}
@@ -0,0 +1,76 @@
+package musiclib
+
+import (
+	"context"
+	"math"
+	"testing"
+)
+
+func TestEditSimilarity(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want float64
+	}{
+		{a: "bjork", b: "bjork", want: 1},
+		{a: "bjrk", b: "bjork", want: 0.8},
+		{a: "beatels", b: "beatles", want: 1 - 1.0/7},
+		{a: "abc", b: "xyz", want: 0},
+		{a: "", b: "", want: 0},
+		{a: "", b: "ab", want: 0},
+	}
+
+	for _, tc := range tests {
+		if got := editSimilarity(tc.a, tc.b); math.Abs(got-tc.want) > 1e-9 {
+			t.Errorf("editSimilarity(%q, %q) = %v, want %v", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
+
+func TestFuzzySearch(t *testing.T) {
+	names := []string{"Björk", "Radiohead", "The Beatles", "abc", "Thelonious Monk"}
+	root := PathMeta{Name: "lib", Path: "lib"}
+	for _, name := range names {
+		root.Children = append(root.Children, PathMeta{
+			Name:     name,
+			Path:     "lib/" + name,
+			Children: []PathMeta{{Name: "01.mp3", Path: "lib/" + name + "/01.mp3"}},
+		})
+	}
+	index := &FileIndex{}
+	if err := index.Index(context.Background(), &Files{Roots: []PathMeta{root}}); err != nil {
+		t.Fatalf("index: %v", err)
+	}
+	roots, _ := index.Roots(context.Background())
+
+	tests := []struct {
+		text string
+		want string
+	}{
+		{text: "bjork", want: "Björk"},
+		// shares no unpadded trigram with "bjork"
+		{text: "bjrk", want: "Björk"},
+		{text: "radiohaed", want: "Radiohead"},
+		{text: "beatels", want: "The Beatles"},
+		// shares no trigram with any name
+		{text: "axc", want: "abc"},
+		{text: "zzzzzzzz"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.text, func(t *testing.T) {
+			matches := fuzzySearch(index, roots, tc.text)
+			if tc.want == "" {
+				if len(matches) > 0 {
+					t.Errorf("got match %q, want none", matches[0].node.Name)
+				}
+				return
+			}
+			if len(matches) == 0 {
+				t.Fatalf("got no matches, want %q", tc.want)
+			}
+			if got := matches[0].node.Name; got != tc.want {
+				t.Errorf("got best match %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
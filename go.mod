@@ -7,14 +7,14 @@ toolchain go1.24.0
 require (
 	github.com/dhowden/tag v0.0.0-20220618230019-adf36e896086
 	github.com/mctofu/musiclib-grpc v0.0.1
+	golang.org/x/sys v0.28.0
+	golang.org/x/text v0.21.0
 	google.golang.org/grpc v1.70.0
 )
 
 require (
 	github.com/golang/protobuf v1.5.4 // indirect
 	golang.org/x/net v0.33.0 // indirect
-	golang.org/x/sys v0.28.0 // indirect
-	golang.org/x/text v0.21.0 // indirect
 	google.golang.org/genproto v0.0.0-20201214200347-8c77b98c765d // indirect
 	google.golang.org/protobuf v1.35.2 // indirect
 )
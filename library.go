@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io/fs"
 	"log"
 	"strings"
 	"sync"
@@ -11,22 +12,76 @@ import (
 
 type ReloadableLibrary struct {
 	rootPaths     []string
+	scanner       *Scanner
+	indexer       *Indexer
+	latestFiles   *Files
+	latestReport  *ScanReport
 	latestLibrary *IndexedLibrary
 	libraryMutex  sync.Mutex
+	loadMutex     sync.Mutex
 }
 
-func NewReloadableLibrary(rootPaths []string) *ReloadableLibrary {
+// NewReloadableLibrary creates a library of rootPaths that is scanned by
+// scanner and indexed by indexer. Nil values use the Scanner and Indexer
+// defaults.
+func NewReloadableLibrary(rootPaths []string, scanner *Scanner, indexer *Indexer) *ReloadableLibrary {
+	if scanner == nil {
+		scanner = &Scanner{}
+	}
+	if indexer == nil {
+		indexer = &Indexer{}
+	}
 	return &ReloadableLibrary{
 		rootPaths: rootPaths,
+		scanner:   scanner,
+		indexer:   indexer,
+	}
+}
+
+// Load scans the root paths and replaces the current library. Files that are
+// unchanged since the previous Load are not re-read.
+func (r *ReloadableLibrary) Load(ctx context.Context) (*ScanReport, error) {
+	r.loadMutex.Lock()
+	defer r.loadMutex.Unlock()
+
+	files, report, err := r.scanner.Scan(ctx, r.rootPaths, r.latestFiles)
+	if err != nil {
+		return nil, fmt.Errorf("Scan: %v", err)
+	}
+	log.Println("Scanned root paths")
+
+	currentLibrary, err := r.indexer.Index(ctx, r.rootPaths, files)
+	if err != nil {
+		return nil, fmt.Errorf("Index: %v", err)
 	}
+	r.latestFiles = files
+
+	r.libraryMutex.Lock()
+	defer r.libraryMutex.Unlock()
+	r.latestLibrary = currentLibrary
+	r.latestReport = report
+
+	return report, nil
 }
 
-func (r *ReloadableLibrary) Load(ctx context.Context) error {
-	currentLibrary, err := NewIndexedLibrary(ctx, r.rootPaths)
+// LoadCache replaces the current library with one built from a cache written
+// by SaveCache. A following Load only re-reads files that changed since the
+// cache was written.
+func (r *ReloadableLibrary) LoadCache(ctx context.Context, cachePath string) error {
+	r.loadMutex.Lock()
+	defer r.loadMutex.Unlock()
+
+	files, err := ReadCacheFile(cachePath, r.rootPaths, r.scanner.FS)
 	if err != nil {
-		return fmt.Errorf("NewIndexedLibrary: %v", err)
+		return fmt.Errorf("ReadCacheFile: %v", err)
 	}
 
+	currentLibrary, err := r.indexer.Index(ctx, r.rootPaths, files)
+	if err != nil {
+		return fmt.Errorf("Index: %v", err)
+	}
+	r.latestFiles = files
+
 	r.libraryMutex.Lock()
 	defer r.libraryMutex.Unlock()
 	r.latestLibrary = currentLibrary
@@ -34,6 +89,26 @@ func (r *ReloadableLibrary) Load(ctx context.Context) error {
 	return nil
 }
 
+// SaveCache writes the most recently loaded files to cachePath.
+func (r *ReloadableLibrary) SaveCache(cachePath string) error {
+	r.loadMutex.Lock()
+	defer r.loadMutex.Unlock()
+
+	if r.latestFiles == nil {
+		return errors.New("library not loaded")
+	}
+
+	return WriteCacheFile(cachePath, r.rootPaths, r.latestFiles)
+}
+
+// ScanReport returns the report of the most recent Load or nil if the library
+// has not been scanned yet.
+func (r *ReloadableLibrary) ScanReport() *ScanReport {
+	r.libraryMutex.Lock()
+	defer r.libraryMutex.Unlock()
+	return r.latestReport
+}
+
 func (r *ReloadableLibrary) Browse(ctx context.Context, browseURI string, opts BrowseOptions) ([]*BrowseItem, error) {
 	return r.library().Browse(ctx, browseURI, opts)
 }
@@ -42,6 +117,22 @@ func (r *ReloadableLibrary) Media(ctx context.Context, uri string, opts BrowseOp
 	return r.library().Media(ctx, uri, opts)
 }
 
+func (r *ReloadableLibrary) BrowsePage(ctx context.Context, browseURI string, opts BrowseOptions) (*BrowsePage, error) {
+	return r.library().BrowsePage(ctx, browseURI, opts)
+}
+
+func (r *ReloadableLibrary) MediaPage(ctx context.Context, uri string, opts BrowseOptions) (*MediaPage, error) {
+	return r.library().MediaPage(ctx, uri, opts)
+}
+
+func (r *ReloadableLibrary) WalkMedia(ctx context.Context, uri string, opts BrowseOptions, chunkSize int, fn func(uris []string) error) error {
+	return r.library().WalkMedia(ctx, uri, opts, chunkSize, fn)
+}
+
+func (r *ReloadableLibrary) Art(ctx context.Context, uri string) (*Art, error) {
+	return r.library().Art(ctx, uri)
+}
+
 func (r *ReloadableLibrary) library() *IndexedLibrary {
 	r.libraryMutex.Lock()
 	defer r.libraryMutex.Unlock()
@@ -55,6 +146,13 @@ type IndexedLibrary struct {
 	Genres       *MetadataIndex
 	Years        *MetadataIndex
 	ModifyDates  *MetadataIndex
+
+	// generation identifies this library in paging cursors.
+	generation uint64
+
+	fsys fs.FS
+	// artSources maps embedded art URIs to a file containing the art.
+	artSources map[string]string
 }
 
 func NewIndexedLibrary(ctx context.Context, rootPaths []string) (*IndexedLibrary, error) {
@@ -64,7 +162,33 @@ func NewIndexedLibrary(ctx context.Context, rootPaths []string) (*IndexedLibrary
 	}
 	log.Println("Scanned root paths")
 
-	artistAlbums := NewArtistAlbumIndex()
+	return IndexFiles(ctx, rootPaths, files)
+}
+
+// IndexFiles builds a library from files that have already been scanned
+// using the Indexer defaults.
+func IndexFiles(ctx context.Context, rootPaths []string, files *Files) (*IndexedLibrary, error) {
+	return (&Indexer{}).Index(ctx, rootPaths, files)
+}
+
+// Indexer builds libraries from scanned files. The zero value is ready to
+// use.
+type Indexer struct {
+	// VariousArtists names the artist compilations are grouped under in the
+	// metadata indexes. Defaults to DefaultVariousArtists.
+	VariousArtists string
+	// Articles are the leading articles ignored when sorting names without
+	// sort tags. Nil uses DefaultArticles.
+	Articles []string
+	// KeepFileOrder leaves songs in the order their files were scanned
+	// instead of sorting them by disc and track in the metadata indexes of
+	// the browse types set.
+	KeepFileOrder map[BrowseType]bool
+}
+
+// Index builds a library from files that have already been scanned.
+func (i *Indexer) Index(ctx context.Context, rootPaths []string, files *Files) (*IndexedLibrary, error) {
+	artistAlbums := i.metadataIndex(BrowseTypeAlbumArtist, NewArtistAlbumIndex())
 	if err := artistAlbums.Index(ctx, files); err != nil {
 		return nil, fmt.Errorf("failed to index artist/albums: %v", err)
 	}
@@ -76,24 +200,42 @@ func NewIndexedLibrary(ctx context.Context, rootPaths []string) (*IndexedLibrary
 	}
 	log.Println("Indexed file paths")
 
-	genreIndex := NewGenreIndex()
+	genreIndex := i.metadataIndex(BrowseTypeGenre, NewGenreIndex())
 	if err := genreIndex.Index(ctx, files); err != nil {
 		return nil, fmt.Errorf("failed to index genres: %v", err)
 	}
 	log.Println("Indexed genres")
 
-	yearIndex := NewYearIndex()
+	yearIndex := i.metadataIndex(BrowseTypeYear, NewYearIndex())
 	if err := yearIndex.Index(ctx, files); err != nil {
 		return nil, fmt.Errorf("failed to index years: %v", err)
 	}
 	log.Println("Indexed years")
 
-	modIndex := NewModifiedAtIndex()
+	modIndex := i.metadataIndex(BrowseTypeModified, NewModifiedAtIndex())
 	if err := modIndex.Index(ctx, files); err != nil {
 		return nil, fmt.Errorf("failed to index modified dates: %v", err)
 	}
 	log.Println("Indexed modified dates")
 
+	artSources := make(map[string]string)
+	if err := files.WalkFiles(func(dir *PathMeta, file *PathMeta) error {
+		if file.Metadata == nil {
+			return nil
+		}
+		if artURI := file.Metadata.AlbumArtURI(); artURI != "" {
+			artSources[artURI] = file.Path
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to index art: %v", err)
+	}
+
+	fsys := files.fsys
+	if fsys == nil {
+		fsys = osFS{}
+	}
+
 	return &IndexedLibrary{
 		RootPaths:    rootPaths,
 		AlbumArtists: artistAlbums,
@@ -101,21 +243,111 @@ func NewIndexedLibrary(ctx context.Context, rootPaths []string) (*IndexedLibrary
 		Genres:       genreIndex,
 		Years:        yearIndex,
 		ModifyDates:  modIndex,
+		generation:   libraryGenerations.Add(1),
+		fsys:         fsys,
+		artSources:   artSources,
 	}, nil
 }
 
+// metadataIndex applies the indexer's options to the index of browseType.
+func (i *Indexer) metadataIndex(browseType BrowseType, index *MetadataIndex) *MetadataIndex {
+	index.VariousArtists = i.VariousArtists
+	index.Articles = i.Articles
+	index.KeepFileOrder = i.KeepFileOrder[browseType]
+	return index
+}
+
+// Browse returns the page of items below browseURI, or the roots if it is
+// empty, selected by opts.
 func (l *IndexedLibrary) Browse(ctx context.Context, browseURI string, opts BrowseOptions) ([]*BrowseItem, error) {
+	page, err := l.BrowsePage(ctx, browseURI, opts)
+	if err != nil {
+		return nil, err
+	}
+	return page.Items, nil
+}
+
+// BrowsePage is like Browse but also returns the total number of items and
+// a cursor for the next page.
+func (l *IndexedLibrary) BrowsePage(ctx context.Context, browseURI string, opts BrowseOptions) (*BrowsePage, error) {
+	req, err := newPageRequest("browse", browseURI, opts)
+	if err != nil {
+		return nil, err
+	}
+	items, err := l.browse(ctx, browseURI, req.opts)
+	if err != nil {
+		return nil, err
+	}
+
+	pageItems, next, err := paginate(items, func(item *BrowseItem) string { return item.URI }, req, l.generation)
+	if err != nil {
+		return nil, err
+	}
+
+	return &BrowsePage{
+		Items:      pageItems,
+		Total:      len(items),
+		NextCursor: next,
+	}, nil
+}
+
+// Media returns the page of song URIs below uri selected by opts.
+func (l *IndexedLibrary) Media(ctx context.Context, uri string, opts BrowseOptions) ([]string, error) {
+	page, err := l.MediaPage(ctx, uri, opts)
+	if err != nil {
+		return nil, err
+	}
+	return page.URIs, nil
+}
+
+// MediaPage is like Media but also returns the total number of URIs and a
+// cursor for the next page.
+func (l *IndexedLibrary) MediaPage(ctx context.Context, uri string, opts BrowseOptions) (*MediaPage, error) {
+	req, err := newPageRequest("media", uri, opts)
+	if err != nil {
+		return nil, err
+	}
+	uris, err := l.media(ctx, uri, req.opts)
+	if err != nil {
+		return nil, err
+	}
+
+	pageURIs, next, err := paginate(uris, func(uri string) string { return uri }, req, l.generation)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MediaPage{
+		URIs:       pageURIs,
+		Total:      len(uris),
+		NextCursor: next,
+	}, nil
+}
+
+func (l *IndexedLibrary) browse(ctx context.Context, browseURI string, opts BrowseOptions) ([]*BrowseItem, error) {
 	index, err := l.index(opts.BrowseType)
 	if err != nil {
 		return nil, err
 	}
 
+	order, err := newNodeOrder(opts)
+	if err != nil {
+		return nil, err
+	}
+
 	if browseURI == "" {
 		rootNodes, err := index.Roots(ctx)
 		if err != nil {
 			return nil, err
 		}
-		return filter(nil, rootNodes, opts.TextFilter)
+		if opts.SearchMode == SearchModeFuzzy {
+			return fuzzyBrowseItems(fuzzySearch(index, rootNodes, opts.TextFilter), order), nil
+		}
+		f, err := newNodeFilter(index, rootNodes, opts)
+		if err != nil {
+			return nil, err
+		}
+		return filter(nil, order.sort(rootNodes), f)
 	}
 
 	node, err := index.Node(ctx, browseURI)
@@ -125,39 +357,119 @@ func (l *IndexedLibrary) Browse(ctx context.Context, browseURI string, opts Brow
 	if node == nil {
 		return nil, nil
 	}
-	return filter(node, node.Children, opts.TextFilter)
+	if opts.SearchMode == SearchModeFuzzy {
+		return fuzzyBrowseItems(fuzzySearch(index, node.Children, opts.TextFilter), order), nil
+	}
+	f, err := newNodeFilter(index, []*Node{node}, opts)
+	if err != nil {
+		return nil, err
+	}
+	return filter(node, order.sort(node.Children), f)
 }
 
-func (l *IndexedLibrary) Media(ctx context.Context, uri string, opts BrowseOptions) ([]string, error) {
+func (l *IndexedLibrary) media(ctx context.Context, uri string, opts BrowseOptions) ([]string, error) {
+	var uris []string
+	if err := l.walkMedia(ctx, uri, opts, func(n *Node) error {
+		uris = append(uris, n.URI)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return uris, nil
+}
+
+// WalkMedia calls fn with the song URIs Media would return in chunks of up
+// to chunkSize as they are found, so callers can start using them before
+// the whole tree is walked. It stops early with ctx's error if ctx is done
+// or with the error fn returns. Paging options are ignored.
+func (l *IndexedLibrary) WalkMedia(ctx context.Context, uri string, opts BrowseOptions, chunkSize int, fn func(uris []string) error) error {
+	chunkSize = max(chunkSize, 1)
+	chunk := make([]string, 0, chunkSize)
+	if err := l.walkMedia(ctx, uri, opts, func(n *Node) error {
+		chunk = append(chunk, n.URI)
+		if len(chunk) < chunkSize {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(chunk); err != nil {
+			return err
+		}
+		chunk = make([]string, 0, chunkSize)
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if len(chunk) == 0 {
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return fn(chunk)
+}
+
+// walkMedia calls walkFn with each song node Media selects.
+func (l *IndexedLibrary) walkMedia(ctx context.Context, uri string, opts BrowseOptions, walkFn WalkNodeFunc) error {
 	index, err := l.index(opts.BrowseType)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	if uri == "" {
-		return nil, errors.New("must specify a uri")
+		return errors.New("must specify a uri")
 	}
 
 	node, err := index.Node(ctx, uri)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	if node == nil {
-		return nil, nil
+		return nil
 	}
 
-	if parentMatch(node.Parent, opts.TextFilter) {
-		var uris []string
-		if err := node.walkLeaves(func(n *Node) error {
-			uris = append(uris, n.URI)
-			return nil
-		}); err != nil {
-			return nil, err
+	order, err := newNodeOrder(opts)
+	if err != nil {
+		return err
+	}
+	if order == nil || order.random == nil {
+		return walkSelectedLeaves(index, node, opts, order, walkFn)
+	}
+
+	// shuffle all songs rather than each level of the tree
+	var leaves []*Node
+	if err := walkSelectedLeaves(index, node, opts, nil, func(n *Node) error {
+		leaves = append(leaves, n)
+		return nil
+	}); err != nil {
+		return err
+	}
+	for _, leaf := range order.sort(leaves) {
+		if err := walkFn(leaf); err != nil {
+			return err
 		}
-		return uris, nil
+	}
+	return nil
+}
+
+// walkSelectedLeaves calls walkFn with the songs below node matching opts in
+// order.
+func walkSelectedLeaves(index Index, node *Node, opts BrowseOptions, order *nodeOrder, walkFn WalkNodeFunc) error {
+	if opts.SearchMode == SearchModeFuzzy {
+		return walkFuzzyLeaves(fuzzySearch(index, []*Node{node}, opts.TextFilter), order, walkFn)
 	}
 
-	return filterLeaves(node, opts.TextFilter)
+	f, err := newNodeFilter(index, []*Node{node}, opts)
+	if err != nil {
+		return err
+	}
+	if f.parentMatch(node.Parent) {
+		return order.walkLeaves(node, walkFn)
+	}
+
+	return filterLeaves(node, f, order, walkFn)
 }
 
 func (l *IndexedLibrary) index(t BrowseType) (Index, error) {
@@ -177,35 +489,119 @@ func (l *IndexedLibrary) index(t BrowseType) (Index, error) {
 	}
 }
 
-func filterLeaves(node *Node, filter string) ([]string, error) {
-	var uris []string
+// textSearcher is implemented by indexes that can find the nodes matching a
+// text filter without walking their trees.
+type textSearcher interface {
+	// searchText returns the nodes whose names contain the folded filter
+	// along with their ancestors.
+	searchText(filter string) map[*Node]bool
+	// searchSimilar returns the nodes that may be similar to the folded
+	// text.
+	searchSimilar(text string) []*Node
+}
 
-	if nodeMatch(node, filter) {
-		if err := node.walkLeaves(func(n *Node) error {
-			uris = append(uris, n.URI)
-			return nil
-		}); err != nil {
+// nodeFilter selects the nodes returned by Browse and Media using either a
+// text filter or a query.
+type nodeFilter struct {
+	// text is the folded text filter.
+	text string
+	// query selects songs when searching with a query.
+	query Query
+	// related holds the matching nodes and their ancestors. It is nil when
+	// matches must be found by walking subtrees.
+	related map[*Node]bool
+}
+
+// newNodeFilter creates the filter for opts on the nodes below parents.
+func newNodeFilter(index Index, parents []*Node, opts BrowseOptions) (*nodeFilter, error) {
+	if opts.SearchMode == SearchModeQuery {
+		query, err := ParseQuery(opts.TextFilter)
+		if err != nil {
 			return nil, err
 		}
-	} else {
-		for _, child := range node.Children {
-			childURIs, err := filterLeaves(child, filter)
-			if err != nil {
-				return nil, err
+		if query == nil {
+			return &nodeFilter{}, nil
+		}
+		return newQueryFilter(parents, query), nil
+	}
+
+	f := &nodeFilter{
+		text: foldText(opts.TextFilter),
+	}
+	if searcher, ok := index.(textSearcher); ok && f.text != "" {
+		f.related = searcher.searchText(f.text)
+	}
+	return f, nil
+}
+
+// newQueryFilter evaluates query against the songs below parents.
+func newQueryFilter(parents []*Node, query Query) *nodeFilter {
+	f := &nodeFilter{
+		query:   query,
+		related: make(map[*Node]bool),
+	}
+	for _, parent := range parents {
+		parent.walkLeaves(func(n *Node) error {
+			if n.file == nil || !query.Match(n.file) {
+				return nil
+			}
+			for p := n; p != nil && !f.related[p]; p = p.Parent {
+				f.related[p] = true
 			}
-			uris = append(uris, childURIs...)
+			return nil
+		})
+	}
+	return f
+}
+
+// parentMatch reports whether everything below parent matches. Queries only
+// match songs.
+func (f *nodeFilter) parentMatch(parent *Node) bool {
+	if f.query != nil {
+		return false
+	}
+	return parentMatch(parent, f.text)
+}
+
+// nodeMatch reports whether n and everything below it matches.
+func (f *nodeFilter) nodeMatch(n *Node) bool {
+	if f.query != nil {
+		return n.file != nil && f.query.Match(n.file)
+	}
+	return nodeMatch(n, f.text)
+}
+
+// nodeOrDescendantMatch reports whether n or any node below it matches.
+func (f *nodeFilter) nodeOrDescendantMatch(n *Node) bool {
+	if f.related != nil {
+		return f.related[n]
+	}
+	return nodeOrDescendantMatch(n, f.text)
+}
+
+func filterLeaves(node *Node, f *nodeFilter, order *nodeOrder, walkFn WalkNodeFunc) error {
+	if f.nodeMatch(node) {
+		return order.walkLeaves(node, walkFn)
+	}
+
+	for _, child := range order.sort(node.Children) {
+		if !f.nodeOrDescendantMatch(child) {
+			continue
+		}
+		if err := filterLeaves(child, f, order, walkFn); err != nil {
+			return err
 		}
 	}
-	return uris, nil
+	return nil
 }
 
-func filter(parent *Node, nodes []*Node, filter string) ([]*BrowseItem, error) {
+func filter(parent *Node, nodes []*Node, f *nodeFilter) ([]*BrowseItem, error) {
 	var results []*BrowseItem
 
-	parentMatches := parentMatch(parent, filter)
+	parentMatches := f.parentMatch(parent)
 
 	for _, n := range nodes {
-		if parentMatches || nodeOrDescendantMatch(n, filter) {
+		if parentMatches || f.nodeOrDescendantMatch(n) {
 			results = append(results, toBrowseItem(n))
 		}
 	}
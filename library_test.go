@@ -0,0 +1,69 @@
+package musiclib
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"testing/fstest"
+)
+
+func TestIndexerKeepFileOrder(t *testing.T) {
+	song := func(title string, track string) *fstest.MapFile {
+		return &fstest.MapFile{Data: id3v23(map[string]string{
+			"TIT2": title,
+			"TPE1": "Artist",
+			"TALB": "Album",
+			"TCON": "Rock",
+			"TRCK": track,
+		}, nil)}
+	}
+	fsys := fstest.MapFS{
+		"lib/Album/a.mp3": song("Last", "3"),
+		"lib/Album/b.mp3": song("First", "1"),
+		"lib/Album/c.mp3": song("Second", "2"),
+	}
+
+	ctx := context.Background()
+	files, _, err := (&Scanner{FS: fsys}).Scan(ctx, []string{"lib"}, nil)
+	if err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	indexer := &Indexer{KeepFileOrder: map[BrowseType]bool{BrowseTypeAlbumArtist: true}}
+	l, err := indexer.Index(ctx, []string{"lib"}, files)
+	if err != nil {
+		t.Fatalf("index: %v", err)
+	}
+
+	tests := []struct {
+		browseType BrowseType
+		uri        string
+		want       []string
+	}{
+		{
+			browseType: BrowseTypeAlbumArtist,
+			uri:        encodeCustomURI("artistalbum", "Artist", "Album"),
+			want:       []string{"Last", "First", "Second"},
+		},
+		{
+			browseType: BrowseTypeGenre,
+			uri:        encodeCustomURI("genrealbum", "Rock", "Artist", "Album"),
+			want:       []string{"First", "Second", "Last"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(string(tc.browseType), func(t *testing.T) {
+			items, err := l.Browse(ctx, tc.uri, BrowseOptions{BrowseType: tc.browseType})
+			if err != nil {
+				t.Fatalf("browse: %v", err)
+			}
+			var got []string
+			for _, item := range items {
+				got = append(got, item.Name)
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
@@ -6,15 +6,24 @@ import (
 	"log"
 	"sort"
 	"strconv"
-	"strings"
 )
 
 type NodeBuilder func(lookup map[string]*Node, dir *PathMeta, file *PathMeta, uriPaths []string) (*Node, []string, bool)
 
 type MetadataIndex struct {
+	// KeepFileOrder leaves songs in the order their files were scanned
+	// instead of sorting them by disc and track.
+	KeepFileOrder bool
+	// VariousArtists names the artist compilations are grouped under.
+	// Defaults to DefaultVariousArtists.
+	VariousArtists string
+	// Articles are the leading articles ignored when sorting names without
+	// sort tags. Nil uses DefaultArticles.
+	Articles  []string
 	uriLookup map[string]*Node
 	builders  []NodeBuilder
 	roots     []*Node
+	text      textIndex
 }
 
 func NewMetadataIndex(builders []NodeBuilder) *MetadataIndex {
@@ -32,7 +41,24 @@ func (a *MetadataIndex) Node(ctx context.Context, uri string) (*Node, error) {
 	return a.uriLookup[uri], nil
 }
 
+func (a *MetadataIndex) searchText(filter string) map[*Node]bool {
+	return a.text.search(filter)
+}
+
+func (a *MetadataIndex) searchSimilar(text string) []*Node {
+	return a.text.similar(text)
+}
+
 func (a *MetadataIndex) Index(ctx context.Context, files *Files) error {
+	compilations, err := findCompilations(files)
+	if err != nil {
+		return err
+	}
+	variousArtists := a.VariousArtists
+	if variousArtists == "" {
+		variousArtists = DefaultVariousArtists
+	}
+
 	if err := files.WalkFiles(func(dir *PathMeta, file *PathMeta) error {
 		if err := ctx.Err(); err != nil {
 			return err
@@ -43,6 +69,11 @@ func (a *MetadataIndex) Index(ctx context.Context, files *Files) error {
 			return nil
 		}
 
+		if compilations[albumKey{dir: dir.Path, album: file.Metadata.Album()}] {
+			file = asCompilation(file, variousArtists)
+		}
+		file.foldMetadata()
+
 		uriPaths := make([]string, 0, len(a.builders))
 
 		var parent *Node
@@ -51,7 +82,8 @@ func (a *MetadataIndex) Index(ctx context.Context, files *Files) error {
 			uriPaths = newPaths
 			if added {
 				a.uriLookup[node.URI] = node
-				node.LowerName = strings.ToLower(node.Name)
+				node.LowerName = foldText(node.Name)
+				a.text.add(node)
 				if parent == nil {
 					a.roots = append(a.roots, node)
 				} else {
@@ -67,24 +99,33 @@ func (a *MetadataIndex) Index(ctx context.Context, files *Files) error {
 		return err
 	}
 
-	sort.Slice(a.roots, nameSort(a.roots))
+	articles := a.Articles
+	if articles == nil {
+		articles = DefaultArticles
+	}
+	sorter := newNameSorter(articles)
+	sorter.sort(a.roots)
 	for _, root := range a.roots {
-		sortChildren(root)
+		a.sortChildren(sorter, root)
+		computeStats(root)
 	}
 
 	return nil
 }
 
-func sortChildren(node *Node) {
+func (a *MetadataIndex) sortChildren(sorter *nameSorter, node *Node) {
 	if !node.IsFolder() {
 		return
 	}
 	if !node.Children[0].IsFolder() {
+		if !a.KeepFileOrder {
+			sort.SliceStable(node.Children, trackSort(node.Children))
+		}
 		return
 	}
-	sort.Slice(node.Children, nameSort(node.Children))
+	sorter.sort(node.Children)
 	for _, child := range node.Children {
-		sortChildren(child)
+		a.sortChildren(sorter, child)
 	}
 }
 
@@ -200,10 +241,13 @@ func artistNodeBuilder(scheme string) NodeBuilder {
 		if !ok {
 			artistNode = &Node{
 				Name:      artist,
-				LowerName: strings.ToLower(artist),
+				LowerName: foldText(artist),
 				URI:       artistURI,
 			}
 		}
+		if artistNode.SortName == "" {
+			artistNode.SortName = file.Metadata.AlbumArtistSort()
+		}
 		return artistNode, uriPaths, !ok
 	}
 }
@@ -216,11 +260,19 @@ func albumNodeBuilder(scheme string) NodeBuilder {
 		albumNode, ok := lookup[albumURI]
 		if !ok {
 			albumNode = &Node{
-				Name:     album,
-				URI:      albumURI,
-				ImageURI: encodeFileURI(dir.ImagePath),
+				Name: album,
+				URI:  albumURI,
 			}
 		}
+		// albums split across directories use the first art found, though
+		// art inherited from a parent directory gives way to a later
+		// track's embedded art
+		if albumNode.ImageURI == "" || albumNode.ImageURI == inheritedArtURI(dir) {
+			albumNode.ImageURI = fileArtURI(dir, file)
+		}
+		if albumNode.SortName == "" {
+			albumNode.SortName = file.Metadata.AlbumSort()
+		}
 
 		return albumNode, uriPaths, !ok
 	}
@@ -235,8 +287,10 @@ func songNode(lookup map[string]*Node, dir *PathMeta, file *PathMeta, uriPaths [
 	}
 	songURI := encodeFileURI(file.Path)
 	songNode := &Node{
-		Name: song,
-		URI:  songURI,
+		Name:     song,
+		URI:      songURI,
+		ImageURI: fileArtURI(dir, file),
+		file:     file,
 	}
 
 	return songNode, uriPaths, true
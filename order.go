@@ -0,0 +1,174 @@
+package musiclib
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"time"
+)
+
+// SortKey selects the order of Browse and Media results.
+type SortKey string
+
+const (
+	// SortDefault keeps the index order: by sort name for metadata indexes
+	// with songs by disc and track, and by file name for files.
+	SortDefault SortKey = ""
+	SortName    SortKey = "name"
+	// SortSortName orders by sort tags or names without leading articles.
+	SortSortName SortKey = "sortname"
+	// SortYear orders by the earliest year of the songs below a node. Nodes
+	// without a year come last in either direction.
+	SortYear SortKey = "year"
+	// SortModified orders by the latest modification time of the songs
+	// below a node.
+	SortModified SortKey = "modified"
+	// SortChildCount orders by the number of direct children, such as the
+	// albums of an artist.
+	SortChildCount SortKey = "childcount"
+	// SortTrackCount orders by the number of songs below a node.
+	SortTrackCount SortKey = "trackcount"
+	// SortDuration orders by the total duration of the songs below a node.
+	SortDuration SortKey = "duration"
+	// SortRandom shuffles results using BrowseOptions.RandomSeed. Media
+	// shuffles all songs rather than each level. Pages continue the shuffle
+	// of the first page through their cursors.
+	SortRandom SortKey = "random"
+)
+
+var sortCompares = map[SortKey]func(a, b *Node) int{
+	SortName: func(a, b *Node) int {
+		return strings.Compare(a.LowerName, b.LowerName)
+	},
+	SortSortName: func(a, b *Node) int {
+		if c := bytes.Compare(a.sortKey, b.sortKey); c != 0 {
+			return c
+		}
+		return strings.Compare(a.LowerName, b.LowerName)
+	},
+	SortYear: func(a, b *Node) int {
+		return cmp.Compare(a.Stats.MinYear, b.Stats.MinYear)
+	},
+	SortModified: func(a, b *Node) int {
+		return a.Stats.Modified.Compare(b.Stats.Modified)
+	},
+	SortChildCount: func(a, b *Node) int {
+		return cmp.Compare(a.Stats.ChildCount, b.Stats.ChildCount)
+	},
+	SortTrackCount: func(a, b *Node) int {
+		return cmp.Compare(a.Stats.TrackCount, b.Stats.TrackCount)
+	},
+	SortDuration: func(a, b *Node) int {
+		return cmp.Compare(a.Stats.Duration, b.Stats.Duration)
+	},
+}
+
+// sortUnknowns report whether a node has no value to sort by for the sort
+// keys that have one. Those nodes sort last.
+var sortUnknowns = map[SortKey]func(n *Node) bool{
+	SortYear: func(n *Node) bool {
+		return n.Stats.MinYear == 0
+	},
+}
+
+// nodeOrder orders sibling nodes as requested by BrowseOptions. A nil
+// nodeOrder keeps the index order.
+type nodeOrder struct {
+	compare func(a, b *Node) int
+	random  *rand.Rand
+}
+
+func newNodeOrder(opts BrowseOptions) (*nodeOrder, error) {
+	switch opts.Sort {
+	case SortDefault:
+		return nil, nil
+	case SortRandom:
+		seed := uint64(opts.RandomSeed)
+		if seed == 0 {
+			seed = uint64(time.Now().UnixNano())
+		}
+		return &nodeOrder{random: rand.New(rand.NewPCG(seed, seed))}, nil
+	}
+
+	compare, ok := sortCompares[opts.Sort]
+	if !ok {
+		return nil, fmt.Errorf("unsupported sort: %s", opts.Sort)
+	}
+	if opts.Descending {
+		ascending := compare
+		compare = func(a, b *Node) int { return ascending(b, a) }
+	}
+	if unknown, ok := sortUnknowns[opts.Sort]; ok {
+		compare = unknownLast(compare, unknown)
+	}
+	return &nodeOrder{compare: compare}, nil
+}
+
+// unknownLast orders the nodes unknown reports after the others.
+func unknownLast(compare func(a, b *Node) int, unknown func(n *Node) bool) func(a, b *Node) int {
+	return func(a, b *Node) int {
+		switch ua, ub := unknown(a), unknown(b); {
+		case ua && ub:
+			return 0
+		case ua:
+			return 1
+		case ub:
+			return -1
+		}
+		return compare(a, b)
+	}
+}
+
+// sort returns nodes in order. Nodes that compare equal keep their index
+// order. The index's slice is never modified.
+func (o *nodeOrder) sort(nodes []*Node) []*Node {
+	if o == nil {
+		return nodes
+	}
+	sorted := slices.Clone(nodes)
+	if o.random != nil {
+		o.random.Shuffle(len(sorted), func(i, j int) {
+			sorted[i], sorted[j] = sorted[j], sorted[i]
+		})
+		return sorted
+	}
+	slices.SortStableFunc(sorted, o.compare)
+	return sorted
+}
+
+// sortMatches returns fuzzy matches in order instead of by score.
+func (o *nodeOrder) sortMatches(matches []fuzzyMatch) []fuzzyMatch {
+	if o == nil {
+		return matches
+	}
+	nodes := make([]*Node, len(matches))
+	byNode := make(map[*Node]fuzzyMatch, len(matches))
+	for i, match := range matches {
+		nodes[i] = match.node
+		byNode[match.node] = match
+	}
+	sorted := make([]fuzzyMatch, 0, len(matches))
+	for _, n := range o.sort(nodes) {
+		sorted = append(sorted, byNode[n])
+	}
+	return sorted
+}
+
+// walkLeaves is like Node.walkLeaves but visits children in order.
+func (o *nodeOrder) walkLeaves(n *Node, walkFn WalkNodeFunc) error {
+	if o == nil {
+		return n.walkLeaves(walkFn)
+	}
+	if len(n.Children) == 0 {
+		return walkFn(n)
+	}
+	for _, child := range o.sort(n.Children) {
+		if err := o.walkLeaves(child, walkFn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package musiclib
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"testing/fstest"
+)
+
+func orderTestLibrary(t *testing.T) *IndexedLibrary {
+	t.Helper()
+	song := func(album string, title string, year string) *fstest.MapFile {
+		frames := map[string]string{
+			"TIT2": title,
+			"TPE1": "Artist",
+			"TALB": album,
+		}
+		if year != "" {
+			frames["TYER"] = year
+		}
+		return &fstest.MapFile{Data: id3v23(frames, nil)}
+	}
+	fsys := fstest.MapFS{
+		"lib/Unknown/1.mp3": song("Unknown", "One", ""),
+		"lib/Unknown/2.mp3": song("Unknown", "Two", ""),
+		"lib/Late/1.mp3":    song("Late", "Three", "2000"),
+		"lib/Late/2.mp3":    song("Late", "Four", "2000"),
+		"lib/Early/1.mp3":   song("Early", "Five", "1990"),
+		"lib/Early/2.mp3":   song("Early", "Six", "1990"),
+		"lib/Early/3.mp3":   song("Early", "Seven", "1990"),
+	}
+
+	ctx := context.Background()
+	files, _, err := (&Scanner{FS: fsys}).Scan(ctx, []string{"lib"}, nil)
+	if err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	l, err := IndexFiles(ctx, []string{"lib"}, files)
+	if err != nil {
+		t.Fatalf("index: %v", err)
+	}
+	return l
+}
+
+func TestSortYear(t *testing.T) {
+	l := orderTestLibrary(t)
+	artist := encodeCustomURI("artist", "Artist")
+
+	tests := []struct {
+		descending bool
+		want       []string
+	}{
+		{descending: false, want: []string{"Early", "Late", "Unknown"}},
+		{descending: true, want: []string{"Late", "Early", "Unknown"}},
+	}
+
+	for _, tc := range tests {
+		items, err := l.Browse(context.Background(), artist, BrowseOptions{
+			BrowseType: BrowseTypeAlbumArtist,
+			Sort:       SortYear,
+			Descending: tc.descending,
+		})
+		if err != nil {
+			t.Fatalf("browse: %v", err)
+		}
+		var got []string
+		for _, item := range items {
+			got = append(got, item.Name)
+		}
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("descending %v: got %q, want %q", tc.descending, got, tc.want)
+		}
+	}
+}
+
+func TestSortRandomPages(t *testing.T) {
+	l := orderTestLibrary(t)
+	ctx := context.Background()
+	artist := encodeCustomURI("artist", "Artist")
+
+	all, err := l.Media(ctx, artist, BrowseOptions{BrowseType: BrowseTypeAlbumArtist})
+	if err != nil {
+		t.Fatalf("media: %v", err)
+	}
+	slices.Sort(all)
+
+	for i := 0; i < 20; i++ {
+		opts := BrowseOptions{BrowseType: BrowseTypeAlbumArtist, Sort: SortRandom, Limit: 2}
+		var got []string
+		for {
+			page, err := l.MediaPage(ctx, artist, opts)
+			if err != nil {
+				t.Fatalf("media page: %v", err)
+			}
+			got = append(got, page.URIs...)
+			if page.NextCursor == "" {
+				break
+			}
+			opts.Cursor = page.NextCursor
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, all) {
+			t.Fatalf("got pages %q, want each of %q once", got, all)
+		}
+	}
+}
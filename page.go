@@ -0,0 +1,211 @@
+package musiclib
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ErrCursorExpired is returned when a cursor can't be continued because the
+// library was reloaded and the last item it returned is gone.
+var ErrCursorExpired = errors.New("cursor expired")
+
+// ErrCursorMismatch is returned when a cursor is used with a request for
+// different results than the one that returned it.
+var ErrCursorMismatch = errors.New("cursor is for a different request")
+
+// BrowsePage is one page of Browse results.
+type BrowsePage struct {
+	Items []*BrowseItem
+	// Total is the number of items across all pages.
+	Total int
+	// NextCursor continues after this page. It is empty on the last page.
+	NextCursor string
+}
+
+// MediaPage is one page of Media results.
+type MediaPage struct {
+	URIs []string
+	// Total is the number of URIs across all pages.
+	Total int
+	// NextCursor continues after this page. It is empty on the last page.
+	NextCursor string
+}
+
+// libraryGenerations identifies each indexed library so cursors made by an
+// earlier one are detected. It starts from the time so generations differ
+// across restarts.
+var libraryGenerations atomic.Uint64
+
+func init() {
+	libraryGenerations.Store(uint64(time.Now().UnixNano()))
+}
+
+// pageRequest holds the options of a Browse or Media request being paged
+// and the cursor it continues from.
+type pageRequest struct {
+	// opts are the request's options with the random seed resolved.
+	opts BrowseOptions
+	// hash identifies the results the request pages through.
+	hash   uint64
+	cursor *cursor
+}
+
+// newPageRequest checks that opts.Cursor was made by a request for the same
+// results as this one. SortRandom without a seed is given one, or the one
+// from the cursor, so following pages continue the same shuffle.
+func newPageRequest(kind string, uri string, opts BrowseOptions) (*pageRequest, error) {
+	r := &pageRequest{
+		opts: opts,
+		hash: requestHash(kind, uri, opts),
+	}
+	if opts.Cursor != "" {
+		c, err := decodeCursor(opts.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		if c.request != r.hash {
+			return nil, ErrCursorMismatch
+		}
+		r.cursor = &c
+	}
+
+	if opts.Sort == SortRandom && opts.RandomSeed == 0 {
+		if r.cursor != nil {
+			r.opts.RandomSeed = r.cursor.seed
+		}
+		for r.opts.RandomSeed == 0 {
+			r.opts.RandomSeed = rand.Int64()
+		}
+	}
+
+	return r, nil
+}
+
+// requestHash hashes the options that select and order the results of the
+// kind of request made for uri. Paging options are left out.
+func requestHash(kind string, uri string, opts BrowseOptions) uint64 {
+	h := fnv.New64a()
+	for _, field := range []string{
+		kind,
+		uri,
+		string(opts.BrowseType),
+		string(opts.SearchMode),
+		opts.TextFilter,
+		string(opts.Sort),
+		strconv.FormatBool(opts.Descending),
+		strconv.FormatInt(opts.RandomSeed, 10),
+	} {
+		h.Write([]byte(field))
+		h.Write([]byte{0})
+	}
+	return h.Sum64()
+}
+
+// cursor records where a page ended. If the library was reloaded since, the
+// next page starts after the last URI returned instead of the offset.
+type cursor struct {
+	generation uint64
+	offset     int
+	// request is the hash of the request the cursor continues.
+	request uint64
+	// seed is the seed a SortRandom request without one was shuffled with.
+	seed    int64
+	lastURI string
+}
+
+func (c cursor) encode() string {
+	s := strconv.FormatUint(c.generation, 10) + "/" + strconv.Itoa(c.offset) + "/" +
+		strconv.FormatUint(c.request, 16) + "/" + strconv.FormatInt(c.seed, 10) + "/" + c.lastURI
+	return base64.RawURLEncoding.EncodeToString([]byte(s))
+}
+
+func decodeCursor(s string) (cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor{}, fmt.Errorf("invalid cursor: %v", err)
+	}
+	parts := strings.SplitN(string(data), "/", 5)
+	if len(parts) != 5 {
+		return cursor{}, errors.New("invalid cursor")
+	}
+	generation, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return cursor{}, fmt.Errorf("invalid cursor: %v", err)
+	}
+	offset, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return cursor{}, fmt.Errorf("invalid cursor: %v", err)
+	}
+	if offset < 0 {
+		return cursor{}, errors.New("invalid cursor")
+	}
+	request, err := strconv.ParseUint(parts[2], 16, 64)
+	if err != nil {
+		return cursor{}, fmt.Errorf("invalid cursor: %v", err)
+	}
+	seed, err := strconv.ParseInt(parts[3], 10, 64)
+	if err != nil {
+		return cursor{}, fmt.Errorf("invalid cursor: %v", err)
+	}
+	return cursor{
+		generation: generation,
+		offset:     offset,
+		request:    request,
+		seed:       seed,
+		lastURI:    parts[4],
+	}, nil
+}
+
+// paginate returns the page of items selected by the Offset, Limit and
+// Cursor options of req along with the cursor of the next page.
+func paginate[T any](items []T, uri func(T) string, req *pageRequest, generation uint64) ([]T, string, error) {
+	opts := req.opts
+	start := max(opts.Offset, 0)
+	if req.cursor != nil {
+		var err error
+		start, err = resumeOffset(*req.cursor, items, uri, generation)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	start = min(max(start, 0), len(items))
+
+	end := len(items)
+	if opts.Limit > 0 {
+		end = start + min(opts.Limit, len(items)-start)
+	}
+
+	var next string
+	if end < len(items) && end > start {
+		c := cursor{
+			generation: generation,
+			offset:     end,
+			request:    req.hash,
+			lastURI:    uri(items[end-1]),
+		}
+		if opts.Sort == SortRandom {
+			c.seed = opts.RandomSeed
+		}
+		next = c.encode()
+	}
+
+	return items[start:end], next, nil
+}
+func resumeOffset[T any](c cursor, items []T, uri func(T) string, generation uint64) (int, error) {
+	if c.generation == generation {
+		return c.offset, nil
+	}
+	for i, item := range items {
+		if uri(item) == c.lastURI {
+			return i + 1, nil
+		}
+	}
+	return 0, ErrCursorExpired
+}
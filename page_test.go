@@ -0,0 +1,227 @@
+package musiclib
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"math"
+	"slices"
+	"strconv"
+	"testing"
+	"testing/fstest"
+)
+
+func TestPaginate(t *testing.T) {
+	items := []string{"a", "b", "c", "d", "e"}
+	const generation = 7
+	hash := requestHash("media", "uri", BrowseOptions{})
+
+	tests := []struct {
+		name     string
+		opts     BrowseOptions
+		want     []string
+		wantNext *cursor
+	}{
+		{
+			name: "all",
+			want: items,
+		},
+		{
+			name:     "first page",
+			opts:     BrowseOptions{Limit: 2},
+			want:     []string{"a", "b"},
+			wantNext: &cursor{generation: generation, offset: 2, request: hash, lastURI: "b"},
+		},
+		{
+			name: "last page",
+			opts: BrowseOptions{Offset: 3, Limit: 2},
+			want: []string{"d", "e"},
+		},
+		{
+			name:     "negative offset",
+			opts:     BrowseOptions{Offset: -5, Limit: 1},
+			want:     []string{"a"},
+			wantNext: &cursor{generation: generation, offset: 1, request: hash, lastURI: "a"},
+		},
+		{
+			name: "offset past end",
+			opts: BrowseOptions{Offset: 10, Limit: 2},
+			want: []string{},
+		},
+		{
+			name: "huge limit",
+			opts: BrowseOptions{Offset: 1, Limit: math.MaxInt},
+			want: []string{"b", "c", "d", "e"},
+		},
+		{
+			name: "cursor same generation",
+			opts: BrowseOptions{
+				Limit:  2,
+				Cursor: cursor{generation: generation, offset: 2, request: hash, lastURI: "b"}.encode(),
+			},
+			want:     []string{"c", "d"},
+			wantNext: &cursor{generation: generation, offset: 4, request: hash, lastURI: "d"},
+		},
+		{
+			name: "cursor after reload",
+			opts: BrowseOptions{
+				Cursor: cursor{generation: 1, offset: 0, request: hash, lastURI: "c"}.encode(),
+			},
+			want: []string{"d", "e"},
+		},
+		{
+			name: "cursor offset past end",
+			opts: BrowseOptions{
+				Limit:  math.MaxInt,
+				Cursor: cursor{generation: generation, offset: 100, request: hash}.encode(),
+			},
+			want: []string{},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := newPageRequest("media", "uri", tc.opts)
+			if err != nil {
+				t.Fatalf("newPageRequest: %v", err)
+			}
+			got, next, err := paginate(items, func(s string) string { return s }, req, generation)
+			if err != nil {
+				t.Fatalf("paginate: %v", err)
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+			wantNext := ""
+			if tc.wantNext != nil {
+				wantNext = tc.wantNext.encode()
+			}
+			if next != wantNext {
+				t.Errorf("got next cursor %q, want %q", next, wantNext)
+			}
+		})
+	}
+}
+
+func TestPaginateBadCursor(t *testing.T) {
+	items := []string{"a", "b", "c"}
+	hash := strconv.FormatUint(requestHash("media", "uri", BrowseOptions{}), 16)
+	raw := func(s string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(s))
+	}
+
+	tests := []struct {
+		name    string
+		cursor  string
+		wantErr error
+	}{
+		{name: "not base64", cursor: "!!!"},
+		{name: "missing parts", cursor: raw("7/1/" + hash)},
+		{name: "bad generation", cursor: raw("x/1/" + hash + "/0/a")},
+		{name: "bad offset", cursor: raw("7/x/" + hash + "/0/a")},
+		{name: "negative offset", cursor: raw("7/-5/" + hash + "/0/a")},
+		{name: "negative offset other generation", cursor: raw("1/-5/" + hash + "/0/a")},
+		{name: "bad request", cursor: raw("7/1/x/0/a")},
+		{name: "bad seed", cursor: raw("7/1/" + hash + "/x/a")},
+		{name: "other request", cursor: raw("7/1/0/0/a"), wantErr: ErrCursorMismatch},
+		{name: "expired", cursor: raw("1/1/" + hash + "/0/gone"), wantErr: ErrCursorExpired},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := newPageRequest("media", "uri", BrowseOptions{Cursor: tc.cursor})
+			if err == nil {
+				_, _, err = paginate(items, func(s string) string { return s }, req, 7)
+			}
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			for _, want := range []error{ErrCursorExpired, ErrCursorMismatch} {
+				if got := errors.Is(err, want); got != (tc.wantErr == want) {
+					t.Errorf("got error %v, want %v", err, tc.wantErr)
+				}
+			}
+		})
+	}
+}
+
+func TestPageCursorMismatch(t *testing.T) {
+	song := func(album string, title string) *fstest.MapFile {
+		return &fstest.MapFile{Data: id3v23(map[string]string{
+			"TIT2": title,
+			"TPE1": "Artist",
+			"TALB": album,
+			"TCON": "Rock",
+		}, nil)}
+	}
+	fsys := fstest.MapFS{
+		"lib/A/1.mp3": song("A", "One"),
+		"lib/A/2.mp3": song("A", "Two"),
+		"lib/A/3.mp3": song("A", "Three"),
+		"lib/B/1.mp3": song("B", "Four"),
+		"lib/B/2.mp3": song("B", "Five"),
+	}
+	ctx := context.Background()
+	files, _, err := (&Scanner{FS: fsys}).Scan(ctx, []string{"lib"}, nil)
+	if err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	l, err := IndexFiles(ctx, []string{"lib"}, files)
+	if err != nil {
+		t.Fatalf("index: %v", err)
+	}
+
+	artist := encodeCustomURI("artist", "Artist")
+	opts := BrowseOptions{BrowseType: BrowseTypeAlbumArtist, Limit: 2}
+	first, err := l.MediaPage(ctx, artist, opts)
+	if err != nil {
+		t.Fatalf("media page: %v", err)
+	}
+	if first.NextCursor == "" {
+		t.Fatal("no next cursor")
+	}
+
+	tests := []struct {
+		name    string
+		uri     string
+		opts    BrowseOptions
+		browse  bool
+		wantErr error
+	}{
+		{name: "same request", uri: artist, opts: opts},
+		{name: "other uri", uri: encodeCustomURI("artistalbum", "Artist", "B"), opts: opts, wantErr: ErrCursorMismatch},
+		{
+			name:    "other filter",
+			uri:     artist,
+			opts:    BrowseOptions{BrowseType: BrowseTypeAlbumArtist, Limit: 2, TextFilter: "f"},
+			wantErr: ErrCursorMismatch,
+		},
+		{
+			name:    "other sort",
+			uri:     artist,
+			opts:    BrowseOptions{BrowseType: BrowseTypeAlbumArtist, Limit: 2, Sort: SortName},
+			wantErr: ErrCursorMismatch,
+		},
+		{
+			name:    "other browse type",
+			uri:     artist,
+			opts:    BrowseOptions{BrowseType: BrowseTypeGenre, Limit: 2},
+			wantErr: ErrCursorMismatch,
+		},
+		{name: "browse", uri: artist, opts: opts, browse: true, wantErr: ErrCursorMismatch},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.opts.Cursor = first.NextCursor
+			if tc.browse {
+				_, err = l.BrowsePage(ctx, tc.uri, tc.opts)
+			} else {
+				_, err = l.MediaPage(ctx, tc.uri, tc.opts)
+			}
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("got error %v, want %v", err, tc.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,271 @@
+package musiclib
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Query is a parsed search query that selects songs by their metadata.
+//
+// A query is a list of terms that must all match. A term is a word or a
+// "quoted phrase" matched against a song's artist, album artist, album,
+// title and genre, ignoring case and diacritics. A term may be limited to
+// one field with a prefix such as artist: or album:, and numeric fields
+// accept inclusive ranges like year:1995..2000, year:..2000 or year:1995..
+// A term starting with - excludes the songs it matches.
+//
+//	artist:radiohead year:1995..2000 genre:rock -live "exact phrase"
+type Query interface {
+	// Match reports whether the song read from file matches.
+	Match(file *PathMeta) bool
+}
+
+// queryTextField identifies a text value of a song that can be searched.
+type queryTextField int
+
+const (
+	queryArtist queryTextField = iota
+	queryAlbumArtist
+	queryAlbum
+	queryTitle
+	queryGenre
+	queryTextFieldCount
+)
+
+// queryTextFields maps field names to the text values they search.
+var queryTextFields = map[string]queryTextField{
+	"artist":      queryArtist,
+	"albumartist": queryAlbumArtist,
+	"album":       queryAlbum,
+	"title":       queryTitle,
+	"song":        queryTitle,
+	"genre":       queryGenre,
+}
+
+// queryTextValues returns each text field's value.
+var queryTextValues = [queryTextFieldCount]func(m MediaMetadata) string{
+	queryArtist:      MediaMetadata.Artist,
+	queryAlbumArtist: MediaMetadata.AlbumArtist,
+	queryAlbum:       MediaMetadata.Album,
+	queryTitle:       MediaMetadata.Song,
+	queryGenre:       MediaMetadata.Genre,
+}
+
+// foldedMetadata holds the text fields of a song folded for matching.
+type foldedMetadata [queryTextFieldCount]string
+
+func foldMetadata(m MediaMetadata) *foldedMetadata {
+	var folded foldedMetadata
+	for field, value := range queryTextValues {
+		folded[field] = foldText(value(m))
+	}
+	return &folded
+}
+
+// queryDefaultFields are searched by terms without a field.
+var queryDefaultFields = []string{"artist", "albumartist", "album", "title", "genre"}
+
+// queryNumberFields returns the numeric values of a song that can be
+// searched.
+var queryNumberFields = map[string]func(m MediaMetadata) int{
+	"year":  MediaMetadata.Year,
+	"track": MediaMetadata.Track,
+	"disc":  MediaMetadata.Disc,
+}
+
+// ParseQuery parses a search query. A query without any terms is nil and
+// matches everything.
+func ParseQuery(s string) (Query, error) {
+	var terms andQuery
+	for _, token := range queryTokens(s) {
+		term, err := parseQueryTerm(token)
+		if err != nil {
+			return nil, err
+		}
+		if term != nil {
+			terms = append(terms, term)
+		}
+	}
+	if len(terms) == 0 {
+		return nil, nil
+	}
+	return terms, nil
+}
+
+// LooksLikeQuery reports whether s uses query syntax: a field prefix, an
+// excluded term or a quoted phrase.
+func LooksLikeQuery(s string) bool {
+	for _, token := range queryTokens(s) {
+		if strings.Contains(token, `"`) {
+			return true
+		}
+		if len(token) > 1 && token[0] == '-' {
+			return true
+		}
+		if field, _, ok := strings.Cut(token, ":"); ok && isQueryField(strings.ToLower(field)) {
+			return true
+		}
+	}
+	return false
+}
+
+func isQueryField(field string) bool {
+	_, text := queryTextFields[field]
+	_, number := queryNumberFields[field]
+	return text || number
+}
+
+// queryTokens splits s on whitespace outside of quotes.
+func queryTokens(s string) []string {
+	var tokens []string
+	var token strings.Builder
+	quoted := false
+	for _, r := range s {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			token.WriteRune(r)
+		case !quoted && (r == ' ' || r == '\t' || r == '\n'):
+			if token.Len() > 0 {
+				tokens = append(tokens, token.String())
+				token.Reset()
+			}
+		default:
+			token.WriteRune(r)
+		}
+	}
+	if token.Len() > 0 {
+		tokens = append(tokens, token.String())
+	}
+	return tokens
+}
+
+func parseQueryTerm(token string) (Query, error) {
+	negate := false
+	if len(token) > 1 && token[0] == '-' {
+		negate = true
+		token = token[1:]
+	}
+
+	fields := queryDefaultFields
+	value := token
+	if field, fieldValue, ok := strings.Cut(token, ":"); ok && isQueryField(strings.ToLower(field)) {
+		fields = []string{strings.ToLower(field)}
+		value = fieldValue
+	}
+	// an unterminated quote runs to the end of the query
+	value = strings.ReplaceAll(value, `"`, "")
+	if value == "" {
+		return nil, nil
+	}
+
+	var term Query
+	if numberField, ok := queryNumberFields[fields[0]]; ok && len(fields) == 1 {
+		r, err := parseQueryRange(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", fields[0], err)
+		}
+		r.field = numberField
+		term = r
+	} else {
+		text := textQuery{text: foldText(value)}
+		for _, field := range fields {
+			text.fields = append(text.fields, queryTextFields[field])
+		}
+		term = &text
+	}
+
+	if negate {
+		return &notQuery{q: term}, nil
+	}
+	return term, nil
+}
+
+func parseQueryRange(value string) (*rangeQuery, error) {
+	low, high, isRange := strings.Cut(value, "..")
+	if !isRange {
+		high = low
+	}
+
+	r := &rangeQuery{}
+	if low != "" {
+		n, err := strconv.Atoi(low)
+		if err != nil {
+			return nil, err
+		}
+		r.low = &n
+	}
+	if high != "" {
+		n, err := strconv.Atoi(high)
+		if err != nil {
+			return nil, err
+		}
+		r.high = &n
+	}
+	return r, nil
+}
+
+type andQuery []Query
+
+func (q andQuery) Match(file *PathMeta) bool {
+	for _, term := range q {
+		if !term.Match(file) {
+			return false
+		}
+	}
+	return true
+}
+
+type notQuery struct {
+	q Query
+}
+
+func (q *notQuery) Match(file *PathMeta) bool {
+	return !q.q.Match(file)
+}
+
+// textQuery matches songs with any of fields containing text.
+type textQuery struct {
+	fields []queryTextField
+	text   string
+}
+
+func (q *textQuery) Match(file *PathMeta) bool {
+	folded := file.foldedMetadata()
+	if folded == nil {
+		return false
+	}
+	for _, field := range q.fields {
+		if strings.Contains(folded[field], q.text) {
+			return true
+		}
+	}
+	return false
+}
+
+// rangeQuery matches songs with field between the optional low and high
+// values inclusive. Ranges without a low value don't match songs where the
+// field is unknown.
+type rangeQuery struct {
+	field func(m MediaMetadata) int
+	low   *int
+	high  *int
+}
+
+func (q *rangeQuery) Match(file *PathMeta) bool {
+	if file.Metadata == nil {
+		return false
+	}
+	v := q.field(file.Metadata)
+	if q.low == nil && v == 0 {
+		return false
+	}
+	if q.low != nil && v < *q.low {
+		return false
+	}
+	if q.high != nil && v > *q.high {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,120 @@
+package musiclib
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseQuery(t *testing.T) {
+	songs := []*PathMeta{
+		{Name: "paranoid", Metadata: &storedMetadata{m: cachedMetadata{
+			Artist:      "Radiohead",
+			AlbumArtist: "Radiohead",
+			Album:       "OK Computer",
+			Song:        "Paranoid Android",
+			Genre:       "Rock",
+			Year:        1997,
+			Track:       2,
+			Disc:        1,
+		}}},
+		{Name: "joga", Metadata: &storedMetadata{m: cachedMetadata{
+			Artist:      "Björk",
+			AlbumArtist: "Björk",
+			Album:       "Homogenic",
+			Song:        "Jóga",
+			Genre:       "Electronic",
+			Year:        1997,
+			Track:       2,
+		}}},
+		{Name: "plates", Metadata: &storedMetadata{m: cachedMetadata{
+			Artist:      "Radiohead",
+			AlbumArtist: "Radiohead",
+			Album:       "I Might Be Wrong (Live)",
+			Song:        "Like Spinning Plates",
+			Genre:       "Rock",
+			Year:        2001,
+		}}},
+		{Name: "demo", Metadata: &storedMetadata{m: cachedMetadata{
+			Artist: "Someone",
+			Album:  "Demo",
+			Song:   "Untitled",
+		}}},
+		{Name: "unread"},
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{query: "radiohead", want: []string{"paranoid", "plates"}},
+		{query: "RADIOHEAD rock", want: []string{"paranoid", "plates"}},
+		{query: "artist:bjork", want: []string{"joga"}},
+		{query: "ARTIST:BJÖRK", want: []string{"joga"}},
+		{query: "title:joga", want: []string{"joga"}},
+		{query: "song:paranoid", want: []string{"paranoid"}},
+		{query: "album:computer", want: []string{"paranoid"}},
+		{query: "albumartist:radiohead -live", want: []string{"paranoid"}},
+		{query: `"ok computer"`, want: []string{"paranoid"}},
+		{query: `"computer ok"`},
+		{query: `album:"might be`, want: []string{"plates"}},
+		{query: "genre:electronic", want: []string{"joga"}},
+		{query: "year:1997", want: []string{"paranoid", "joga"}},
+		{query: "year:1998..2001", want: []string{"plates"}},
+		{query: "year:2000..", want: []string{"plates"}},
+		{query: "year:..1999", want: []string{"paranoid", "joga"}},
+		{query: "year:..", want: []string{"paranoid", "joga", "plates"}},
+		{query: "-year:1997", want: []string{"plates", "demo", "unread"}},
+		{query: "track:2 disc:1", want: []string{"paranoid"}},
+		// unknown fields are searched as text
+		{query: "mood:happy"},
+		{query: "artist:", want: []string{"paranoid", "joga", "plates", "demo", "unread"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.query, func(t *testing.T) {
+			q, err := ParseQuery(tc.query)
+			if err != nil {
+				t.Fatalf("ParseQuery: %v", err)
+			}
+			var got []string
+			for _, s := range songs {
+				if q == nil || q.Match(s) {
+					got = append(got, s.Name)
+				}
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	for _, query := range []string{"year:abc", "year:1990..x", "track:..two", "-disc:one"} {
+		if q, err := ParseQuery(query); err == nil {
+			t.Errorf("ParseQuery(%q) = %v, want error", query, q)
+		}
+	}
+}
+
+func TestLooksLikeQuery(t *testing.T) {
+	tests := []struct {
+		text string
+		want bool
+	}{
+		{text: "radiohead", want: false},
+		{text: "ok computer", want: false},
+		{text: "AC/DC: live", want: false},
+		{text: "-", want: false},
+		{text: "artist:radiohead", want: true},
+		{text: "Year:1997", want: true},
+		{text: "radiohead -live", want: true},
+		{text: `"ok computer"`, want: true},
+	}
+
+	for _, tc := range tests {
+		if got := LooksLikeQuery(tc.text); got != tc.want {
+			t.Errorf("LooksLikeQuery(%q) = %v, want %v", tc.text, got, tc.want)
+		}
+	}
+}
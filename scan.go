@@ -1,30 +1,37 @@
 package musiclib
 
 import (
+	"bytes"
 	"context"
 	"fmt"
-	"io/ioutil"
-	"log"
+	"io"
+	"io/fs"
 	"os"
 	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/dhowden/tag"
 )
 
-var tagExts = map[string]struct{}{
-	".flac": {},
-	".m4a":  {},
-	".mp3":  {},
-	".ogg":  {},
-	".opus": {},
-	".wav":  {},
+var imgExts = map[string]struct{}{
+	".gif":  {},
+	".jpeg": {},
+	".jpg":  {},
+	".png":  {},
 }
 
-var imgExts = map[string]struct{}{
-	".gif": {},
-	".jpg": {},
-	".png": {},
+// textExts are files commonly kept alongside media, such as rip logs and cue
+// sheets, that are never sniffed. Their content can resemble media headers.
+var textExts = map[string]struct{}{
+	".cue": {},
+	".log": {},
+	".m3u": {},
+	".nfo": {},
+	".txt": {},
 }
 
 type WalkFunc func(dir *PathMeta, file *PathMeta) error
@@ -34,15 +41,33 @@ type MediaMetadata interface {
 	AlbumArtist() string
 	Album() string
 	Song() string
+	// Sort names are empty unless they are tagged.
+	ArtistSort() string
+	AlbumArtistSort() string
+	AlbumSort() string
 	AlbumArtURI() string
 	Track() int
+	Disc() int
+	DiscTotal() int
 	Genre() string
+	// Compilation reports whether the file is tagged as part of a
+	// compilation.
+	Compilation() bool
 	Modified() time.Time
 	Year() int
+	// Stream info is zero when unknown.
+	Duration() time.Duration
+	// Bitrate is the average bitrate in bits per second.
+	Bitrate() int
+	SampleRate() int
+	BitDepth() int
+	Channels() int
 }
 
 type Files struct {
 	Roots []PathMeta
+	// fsys is the filesystem the files were scanned from.
+	fsys fs.FS
 }
 
 func (f *Files) WalkFiles(walkFn WalkFunc) error {
@@ -59,9 +84,35 @@ type PathMeta struct {
 	Name      string
 	Path      string
 	ImagePath string
-	Metadata  MediaMetadata
-	Parent    *PathMeta
-	Children  []PathMeta
+	// ImageInherited is set when ImagePath is a parent directory's image
+	// rather than one found in this directory.
+	ImageInherited bool
+	Size           int64
+	ModTime        time.Time
+	Metadata       MediaMetadata
+	Parent         *PathMeta
+	Children       []PathMeta
+
+	// folded holds the metadata folded for queries once the file is
+	// indexed.
+	folded *foldedMetadata
+}
+
+// foldMetadata folds the text metadata of the file so queries don't fold it
+// each time they're matched.
+func (f *PathMeta) foldMetadata() {
+	if f.folded == nil && f.Metadata != nil {
+		f.folded = foldMetadata(f.Metadata)
+	}
+}
+
+// foldedMetadata returns the folded metadata of the file, folding it if the
+// file isn't indexed. It is nil for files without metadata.
+func (f *PathMeta) foldedMetadata() *foldedMetadata {
+	if f.folded != nil || f.Metadata == nil {
+		return f.folded
+	}
+	return foldMetadata(f.Metadata)
 }
 
 func (f *PathMeta) IsDir() bool {
@@ -84,44 +135,307 @@ func (f *PathMeta) walkChildren(walkFn WalkFunc) error {
 	return nil
 }
 
+// ScanReport summarizes how a scan compared to the previous scan of the
+// same roots and which paths could not be scanned.
+type ScanReport struct {
+	Added   int
+	Changed int
+	Removed int
+	Reused  int
+	Errors  []ScanError
+}
+
+// Operations recorded in a ScanError.
+const (
+	ScanOpReadDir  = "readdir"
+	ScanOpOpen     = "open"
+	ScanOpStat     = "stat"
+	ScanOpReadTag  = "readtag"
+	ScanOpReadInfo = "readinfo"
+)
+
+// ScanError describes a path that could not be fully scanned.
+type ScanError struct {
+	Path string
+	Op   string
+	Err  error
+}
+
+func (e *ScanError) Error() string {
+	return e.Op + " " + e.Path + ": " + e.Err.Error()
+}
+
+func (e *ScanError) Unwrap() error {
+	return e.Err
+}
+
+// DefaultArtNames are the preferred image names for a directory's art.
+var DefaultArtNames = []string{"cover", "folder", "front", "album"}
+
+// Scanner reads media metadata from directory trees. The zero value is ready
+// to use.
+type Scanner struct {
+	// Workers is the number of files to read tags from concurrently. Values
+	// less than 1 read one file at a time.
+	Workers int
+	// SniffContent classifies files by their leading bytes so media and
+	// images with a missing or wrong extension are found. Files that can't
+	// be identified from their content are classified by extension.
+	SniffContent bool
+	// ArtNames lists image file names without extension in order of
+	// preference for a directory's art. Names are compared
+	// case-insensitively. Directories without an image use their parent's
+	// art. Defaults to DefaultArtNames.
+	ArtNames []string
+	// SkipErrors skips directories and files that can't be read instead of
+	// failing the scan. Skipped paths are recorded in the ScanReport.
+	SkipErrors bool
+	// FS is the filesystem the roots are read from. Defaults to the operating
+	// system's filesystem, where roots are native paths. Files from an FS
+	// that don't support seeking are read into memory to parse their tags.
+	FS fs.FS
+}
+
 func ScanRoots(ctx context.Context, roots []string) (*Files, error) {
+	files, _, err := (&Scanner{}).Scan(ctx, roots, nil)
+	return files, err
+}
+
+// RescanRoots scans roots like ScanRoots but reuses the metadata of files in
+// previous whose size and modification time are unchanged so only new or
+// changed files have their tags read.
+func RescanRoots(ctx context.Context, roots []string, previous *Files) (*Files, *ScanReport, error) {
+	return (&Scanner{}).Scan(ctx, roots, previous)
+}
+
+// Scan walks roots and reads tags from the media files found. If previous is
+// not nil the metadata of unchanged files is reused from it.
+func (s *Scanner) Scan(ctx context.Context, roots []string, previous *Files) (*Files, *ScanReport, error) {
+	fsys := s.FS
+	if fsys == nil {
+		fsys = osFS{}
+	}
+
+	state := &scanState{
+		fsys:       fsys,
+		previous:   make(map[string]*PathMeta),
+		report:     &ScanReport{},
+		skipErrors: s.SkipErrors,
+		sniff:      s.SniffContent,
+		artNames:   s.ArtNames,
+	}
+	if state.artNames == nil {
+		state.artNames = DefaultArtNames
+	}
+	if state.sniff {
+		state.sniffLen = sniffLen()
+	}
+	if previous != nil {
+		if err := previous.WalkFiles(func(dir *PathMeta, file *PathMeta) error {
+			state.previous[file.Path] = file
+			return nil
+		}); err != nil {
+			return nil, nil, err
+		}
+	}
+
 	var rootMetas []PathMeta
 	for _, root := range roots {
-		meta, err := scanDir(ctx, path.Base(root), root)
+		meta, err := state.scanDir(ctx, path.Base(root), root, "")
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		if meta != nil {
 			rootMetas = append(rootMetas, *meta)
 		}
 	}
 
+	if err := s.readFiles(ctx, state); err != nil {
+		return nil, nil, err
+	}
+	if state.skipped {
+		rootMetas = pruneUnread(rootMetas)
+	}
+	// files are read concurrently so order errors by path for stable reports
+	sort.SliceStable(state.report.Errors, func(i, j int) bool {
+		return state.report.Errors[i].Path < state.report.Errors[j].Path
+	})
+
+	state.report.Removed = len(state.previous) - state.report.Changed - state.report.Reused
+
 	return &Files{
 		Roots: rootMetas,
-	}, nil
+		fsys:  fsys,
+	}, state.report, nil
+}
+
+// readFiles reads tags into the unread files using a pool of workers.
+func (s *Scanner) readFiles(ctx context.Context, state *scanState) error {
+	workers := s.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	jobs := make(chan unreadFile)
+	errs := make(chan error, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				if err := state.readFile(job.file, job.format); err != nil {
+					errs <- err
+					cancel()
+					return
+				}
+			}
+		}()
+	}
+
+	var err error
+	for _, job := range state.unread {
+		if err = ctx.Err(); err != nil {
+			break
+		}
+		select {
+		case jobs <- job:
+		case <-ctx.Done():
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	close(errs)
+
+	// prefer the error that cancelled the scan
+	if readErr, ok := <-errs; ok {
+		return readErr
+	}
+	if err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+type mediaFile struct {
+	info   fs.FileInfo
+	format *Format
+}
+
+type unreadFile struct {
+	file   *PathMeta
+	format *Format
+}
+
+type scanState struct {
+	fsys       fs.FS
+	previous   map[string]*PathMeta
+	skipErrors bool
+	sniff      bool
+	sniffLen   int
+	artNames   []string
+	// unread holds files that need their tags read once the tree is built.
+	unread []unreadFile
+
+	reportMutex sync.Mutex
+	report      *ScanReport
+	// skipped is set when an unread file couldn't be opened and must be
+	// pruned from the tree.
+	skipped bool
+}
+
+func (s *scanState) addError(filePath string, op string, err error) {
+	s.reportMutex.Lock()
+	defer s.reportMutex.Unlock()
+	s.report.Errors = append(s.report.Errors, ScanError{
+		Path: filePath,
+		Op:   op,
+		Err:  err,
+	})
+}
+
+// skipError records err and returns nil if errors are being skipped.
+func (s *scanState) skipError(filePath string, op string, err error) error {
+	if !s.skipErrors {
+		return err
+	}
+	s.addError(filePath, op, err)
+	return nil
 }
 
-func scanDir(ctx context.Context, name string, dir string) (*PathMeta, error) {
+// scanDir scans a directory. parentImage is used as the directory's image if
+// it doesn't contain one.
+func (s *scanState) scanDir(ctx context.Context, name string, dir string, parentImage string) (*PathMeta, error) {
 	meta := &PathMeta{
 		Name: name,
 		Path: dir,
 	}
 
-	files, err := ioutil.ReadDir(dir)
+	files, err := fs.ReadDir(s.fsys, dir)
 	if err != nil {
-		return nil, err
+		return nil, s.skipError(dir, ScanOpReadDir, err)
 	}
 	if len(files) == 0 {
 		return nil, nil
 	}
 
+	// classify files first so the directory's image is known before
+	// scanning subdirectories
+	media := make(map[string]mediaFile)
+	var images []string
 	for _, file := range files {
 		if err := ctx.Err(); err != nil {
 			return nil, err
 		}
 
 		if file.IsDir() {
-			child, err := scanDir(ctx, file.Name(), path.Join(dir, file.Name()))
+			continue
+		}
+
+		info, err := file.Info()
+		if err != nil {
+			if err := s.skipError(path.Join(dir, file.Name()), ScanOpStat, err); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+
+		isImage, format, err := s.classify(path.Join(dir, file.Name()), info)
+		if err != nil {
+			return nil, err
+		}
+		if isImage {
+			images = append(images, file.Name())
+			continue
+		}
+		if format != nil {
+			media[file.Name()] = mediaFile{info: info, format: format}
+		}
+	}
+
+	meta.ImagePath = parentImage
+	meta.ImageInherited = parentImage != ""
+	if art := s.selectArt(images); art != "" {
+		meta.ImagePath = path.Join(dir, art)
+		meta.ImageInherited = false
+	}
+
+	// formats of the children that need their tags read by child index
+	unreadFormats := make(map[int]*Format)
+
+	for _, file := range files {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		if file.IsDir() {
+			child, err := s.scanDir(ctx, file.Name(), path.Join(dir, file.Name()), meta.ImagePath)
 			if err != nil {
 				return nil, err
 			}
@@ -133,61 +447,242 @@ func scanDir(ctx context.Context, name string, dir string) (*PathMeta, error) {
 			continue
 		}
 
-		if !file.Mode().IsRegular() {
+		mf, ok := media[file.Name()]
+		if !ok {
 			continue
 		}
 
-		fileExt := path.Ext(file.Name())
-		if _, ok := imgExts[fileExt]; ok {
-			meta.ImagePath = path.Join(dir, file.Name())
-			continue
+		child := &PathMeta{
+			Name:    file.Name(),
+			Path:    path.Join(dir, file.Name()),
+			Size:    mf.info.Size(),
+			ModTime: mf.info.ModTime(),
 		}
-		if _, ok := tagExts[fileExt]; !ok {
-			continue
+		child.Metadata = s.previousMetadata(child)
+
+		child.Parent = meta
+		meta.Children = append(meta.Children, *child)
+		if child.Metadata == nil {
+			unreadFormats[len(meta.Children)-1] = mf.format
+		}
+	}
+
+	// Children won't be appended to anymore so pointers to them stay valid
+	// while the tags are read.
+	for i := range meta.Children {
+		if format, ok := unreadFormats[i]; ok {
+			s.unread = append(s.unread, unreadFile{file: &meta.Children[i], format: format})
+		}
+	}
+
+	return meta, nil
+}
+
+// selectArt picks the image file name that best matches the art names.
+// Images that don't match any art name are used if nothing else is found.
+func (s *scanState) selectArt(images []string) string {
+	best := ""
+	bestRank := len(s.artNames) + 1
+	for _, image := range images {
+		stem := strings.ToLower(strings.TrimSuffix(image, path.Ext(image)))
+		rank := len(s.artNames)
+		for i, artName := range s.artNames {
+			if stem == strings.ToLower(artName) {
+				rank = i
+				break
+			}
+		}
+		if rank < bestRank {
+			best = image
+			bestRank = rank
 		}
+	}
+	return best
+}
 
-		// try to read tags from media files
-		filePath := path.Join(dir, file.Name())
-		child, err := readFile(filePath)
+// classify determines whether a file is an image or a media file and the
+// format of a media file.
+func (s *scanState) classify(filePath string, info fs.FileInfo) (bool, *Format, error) {
+	fileExt := strings.ToLower(path.Ext(filePath))
+	extFormat := formatForExt(fileExt)
+
+	// media files unchanged since the previous scan keep their metadata so
+	// there's no need to look at their content again
+	_, isText := textExts[fileExt]
+	if s.sniff && !isText && (extFormat == nil || !s.unchanged(filePath, info)) {
+		isImage, format, err := s.sniffFile(filePath)
 		if err != nil {
-			return nil, err
+			return false, nil, s.skipError(filePath, ScanOpOpen, err)
+		}
+		if isImage || format != nil {
+			return isImage, format, nil
 		}
-		child.Name = file.Name()
+	}
 
-		child.Parent = meta
-		meta.Children = append(meta.Children, *child)
+	if _, ok := imgExts[fileExt]; ok {
+		return true, nil, nil
 	}
+	return false, extFormat, nil
+}
 
-	return meta, nil
+func (s *scanState) sniffFile(filePath string) (bool, *Format, error) {
+	f, err := s.fsys.Open(filePath)
+	if err != nil {
+		return false, nil, err
+	}
+	defer f.Close()
+
+	header := make([]byte, s.sniffLen)
+	n, err := io.ReadFull(f, header)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return false, nil, err
+	}
+	header = header[:n]
+
+	for _, sig := range imgSignatures {
+		if matchSignature(header, sig) {
+			return true, nil, nil
+		}
+	}
+	return false, formatForHeader(header), nil
+}
+
+func (s *scanState) unchanged(filePath string, info fs.FileInfo) bool {
+	prev, ok := s.previous[filePath]
+	return ok && prev.Size == info.Size() && prev.ModTime.Equal(info.ModTime())
+}
+
+// previousMetadata returns the metadata from the previous scan if the file is
+// unchanged.
+func (s *scanState) previousMetadata(file *PathMeta) MediaMetadata {
+	prev, ok := s.previous[file.Path]
+	if !ok {
+		s.report.Added++
+		return nil
+	}
+	if prev.Size == file.Size && prev.ModTime.Equal(file.ModTime) {
+		s.report.Reused++
+		return prev.Metadata
+	}
+	s.report.Changed++
+	return nil
+}
+
+// skipFile records that a file couldn't be opened so it is pruned from the
+// tree once all files are read.
+func (s *scanState) skipFile(filePath string, err error) error {
+	if err := s.skipError(filePath, ScanOpOpen, err); err != nil {
+		return err
+	}
+	s.reportMutex.Lock()
+	defer s.reportMutex.Unlock()
+	s.skipped = true
+	return nil
 }
 
-func readFile(filePath string) (*PathMeta, error) {
-	f, err := os.Open(filePath)
+// readFile tries to read tags from a media file, falling back to metadata
+// derived from the file name. Files that can't be opened are left without
+// metadata when errors are skipped.
+func (s *scanState) readFile(file *PathMeta, format *Format) error {
+	f, err := s.fsys.Open(file.Path)
 	if err != nil {
-		return nil, err
+		return s.skipFile(file.Path, err)
 	}
 	defer f.Close()
 
-	tagMeta, err := tag.ReadFrom(f)
+	r, err := readSeeker(f)
+	if err != nil {
+		return s.skipFile(file.Path, err)
+	}
+
+	tagMeta, err := format.ReadTags(r)
 	if err != nil && err != tag.ErrNoTagsFound {
-		log.Printf("failed to read tag from %s: %v\n", filePath, err)
+		s.addError(file.Path, ScanOpReadTag, err)
+	}
+	if tagMeta == nil || (tagMeta.Title() == "" && tagMeta.Artist() == "" && tagMeta.Album() == "") {
+		var dirName string
+		if file.Parent != nil {
+			dirName = file.Parent.Name
+		}
+		filenameMeta := newFilenameMetadata(file.Name, dirName)
+		if tagMeta == nil {
+			tagMeta = filenameMeta
+		} else {
+			// keep the numbers, genre and art the tags do have
+			tagMeta = &filenameFallbackMetadata{Metadata: tagMeta, filename: filenameMeta}
+		}
+	}
+
+	var audioInfo AudioInfo
+	if format.ReadInfo != nil {
+		audioInfo, err = format.ReadInfo(r, file.Size)
+		if err != nil {
+			s.addError(file.Path, ScanOpReadInfo, err)
+		}
 	}
 
 	info, err := f.Stat()
 	if err != nil {
-		log.Printf("failed to stat %s: %v\n", filePath, err)
+		s.addError(file.Path, ScanOpStat, err)
 	}
 
-	meta := &PathMeta{
-		Path: filePath,
+	file.Metadata = &mediaMetadataReader{
+		tagData:   tagMeta,
+		fileName:  file.Name,
+		info:      info,
+		artURI:    embeddedArtURI(tagMeta.Picture()),
+		audioInfo: audioInfo,
 	}
-	meta.Metadata = &mediaMetadataReader{
-		tagData: tagMeta,
-		file:    meta,
-		info:    info,
+
+	return nil
+}
+
+// readSeeker returns f if it supports seeking, otherwise it reads the whole
+// file into memory.
+func readSeeker(f fs.File) (io.ReadSeeker, error) {
+	if r, ok := f.(io.ReadSeeker); ok {
+		return r, nil
 	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(data), nil
+}
 
-	return meta, nil
+// osFS exposes the operating system's filesystem as an fs.FS. Unlike
+// os.DirFS it accepts native absolute paths.
+type osFS struct{}
+
+func (osFS) Open(name string) (fs.File, error) {
+	return os.Open(name)
+}
+
+func (osFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
+func (osFS) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+// pruneUnread removes files without metadata and the directories left empty
+// by removing them.
+func pruneUnread(metas []PathMeta) []PathMeta {
+	var kept []PathMeta
+	for _, meta := range metas {
+		if !meta.IsDir() {
+			if meta.Metadata != nil {
+				kept = append(kept, meta)
+			}
+			continue
+		}
+		meta.Children = pruneUnread(meta.Children)
+		if len(meta.Children) > 0 {
+			kept = append(kept, meta)
+		}
+	}
+	return kept
 }
 
 const (
@@ -198,8 +693,12 @@ const (
 
 type mediaMetadataReader struct {
 	tagData tag.Metadata
-	file    *PathMeta
-	info    os.FileInfo
+	// fileName is kept rather than the PathMeta so reused metadata doesn't
+	// hold on to the tree of the scan that read it.
+	fileName  string
+	info      fs.FileInfo
+	artURI    string
+	audioInfo AudioInfo
 }
 
 func (m *mediaMetadataReader) Artist() string {
@@ -245,19 +744,58 @@ func (m *mediaMetadataReader) Album() string {
 
 func (m *mediaMetadataReader) Song() string {
 	if m.tagData == nil {
-		return m.file.Name
+		return m.fileName
 	}
 	song := m.tagData.Title()
 	if song != "" {
 		return song
 	}
-	return m.file.Name
+	return m.fileName
 }
 
-func (m *mediaMetadataReader) AlbumArtURI() string {
+// Raw tag keys of the ID3v2, ID3v2.2 and Vorbis/APE sort tags.
+var (
+	artistSortKeys      = []string{"TSOP", "TSP", "artistsort"}
+	albumArtistSortKeys = []string{"TSO2", "TS2", "albumartistsort"}
+	albumSortKeys       = []string{"TSOA", "TSA", "albumsort"}
+)
+
+func (m *mediaMetadataReader) rawText(keys []string) string {
+	if m.tagData == nil {
+		return ""
+	}
+	raw := m.tagData.Raw()
+	for _, key := range keys {
+		if v, ok := raw[key].(string); ok && v != "" {
+			return v
+		}
+	}
 	return ""
 }
 
+func (m *mediaMetadataReader) ArtistSort() string {
+	return m.rawText(artistSortKeys)
+}
+
+func (m *mediaMetadataReader) AlbumArtistSort() string {
+	if sortName := m.rawText(albumArtistSortKeys); sortName != "" {
+		return sortName
+	}
+	// the album artist falls back to the artist when it isn't tagged
+	if m.tagData != nil && m.tagData.AlbumArtist() == "" && m.tagData.Raw()["album artist"] == nil {
+		return m.ArtistSort()
+	}
+	return ""
+}
+
+func (m *mediaMetadataReader) AlbumSort() string {
+	return m.rawText(albumSortKeys)
+}
+
+func (m *mediaMetadataReader) AlbumArtURI() string {
+	return m.artURI
+}
+
 func (m *mediaMetadataReader) Track() int {
 	if m.tagData == nil {
 		return 0
@@ -266,6 +804,22 @@ func (m *mediaMetadataReader) Track() int {
 	return t
 }
 
+func (m *mediaMetadataReader) Disc() int {
+	if m.tagData == nil {
+		return 0
+	}
+	d, _ := m.tagData.Disc()
+	return d
+}
+
+func (m *mediaMetadataReader) DiscTotal() int {
+	if m.tagData == nil {
+		return 0
+	}
+	_, total := m.tagData.Disc()
+	return total
+}
+
 func (m *mediaMetadataReader) Genre() string {
 	if m.tagData == nil {
 		return unknownGenre
@@ -277,6 +831,30 @@ func (m *mediaMetadataReader) Genre() string {
 	return unknownGenre
 }
 
+// compilationKeys are the raw tag keys of the ID3v2, ID3v2.2, MP4 and
+// Vorbis/APE compilation flags.
+var compilationKeys = []string{"TCMP", "TCP", "cpil", "compilation"}
+
+func (m *mediaMetadataReader) Compilation() bool {
+	if m.tagData == nil {
+		return false
+	}
+	raw := m.tagData.Raw()
+	for _, key := range compilationKeys {
+		switch v := raw[key].(type) {
+		case int:
+			if v != 0 {
+				return true
+			}
+		case string:
+			if flag, _ := strconv.ParseBool(strings.TrimSpace(v)); flag {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func (m *mediaMetadataReader) Year() int {
 	if m.tagData == nil {
 		return 0
@@ -290,3 +868,23 @@ func (m *mediaMetadataReader) Modified() time.Time {
 	}
 	return m.info.ModTime()
 }
+
+func (m *mediaMetadataReader) Duration() time.Duration {
+	return m.audioInfo.Duration
+}
+
+func (m *mediaMetadataReader) Bitrate() int {
+	return m.audioInfo.Bitrate
+}
+
+func (m *mediaMetadataReader) SampleRate() int {
+	return m.audioInfo.SampleRate
+}
+
+func (m *mediaMetadataReader) BitDepth() int {
+	return m.audioInfo.BitDepth
+}
+
+func (m *mediaMetadataReader) Channels() int {
+	return m.audioInfo.Channels
+}
@@ -0,0 +1,341 @@
+package musiclib
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+	"unicode/utf16"
+)
+
+// mp3Frame is an MPEG 1 layer III frame header at 128 kbps and 44.1 kHz
+// followed by an empty frame body.
+var mp3Frame = append([]byte{0xff, 0xfb, 0x90, 0x64}, make([]byte, 413)...)
+
+func utf16LE(s string) []byte {
+	var b bytes.Buffer
+	b.Write([]byte{0xff, 0xfe})
+	for _, u := range utf16.Encode([]rune(s)) {
+		b.Write([]byte{byte(u), byte(u >> 8)})
+	}
+	return b.Bytes()
+}
+
+func TestIsMP3FrameHeader(t *testing.T) {
+	tests := []struct {
+		name   string
+		header []byte
+		want   bool
+	}{
+		{name: "mpeg 1 layer III", header: []byte{0xff, 0xfb, 0x90, 0x64}, want: true},
+		{name: "mpeg 2 layer III", header: []byte{0xff, 0xf3, 0x80, 0xc4}, want: true},
+		{name: "utf-16le bom", header: utf16LE("Exact Audio Copy"), want: false},
+		{name: "layer I", header: []byte{0xff, 0xff, 0x90, 0x64}, want: false},
+		{name: "reserved version", header: []byte{0xff, 0xeb, 0x90, 0x64}, want: false},
+		{name: "bad bitrate", header: []byte{0xff, 0xfb, 0xf0, 0x64}, want: false},
+		{name: "reserved sample rate", header: []byte{0xff, 0xfb, 0x9c, 0x64}, want: false},
+		{name: "truncated", header: []byte{0xff, 0xfb, 0x90}, want: false},
+		{name: "no sync", header: []byte{0xfe, 0xfb, 0x90, 0x64}, want: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := isMP3FrameHeader(tc.header); got != tc.want {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestScanSniffContent(t *testing.T) {
+	fsys := fstest.MapFS{
+		"lib/Alb/Alb.log":   {Data: utf16LE("Exact Audio Copy V1.0\r\n")},
+		"lib/Alb/Alb.cue":   {Data: mp3Frame},
+		"lib/Alb/notes":     {Data: utf16LE("Exact Audio Copy V1.0\r\n")},
+		"lib/Alb/01 Track":  {Data: mp3Frame},
+		"lib/Alb/02 Track":  {Data: append([]byte("ID3\x03\x00\x00\x00\x00\x00\x00"), mp3Frame...)},
+		"lib/Alb/cover.bin": {Data: []byte("\x89PNG\r\n\x1a\n")},
+	}
+
+	files, _, err := (&Scanner{FS: fsys, SniffContent: true}).Scan(context.Background(), []string{"lib"}, nil)
+	if err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+
+	var got []string
+	if err := files.WalkFiles(func(dir *PathMeta, file *PathMeta) error {
+		got = append(got, file.Path)
+		if dir.ImagePath != "lib/Alb/cover.bin" {
+			t.Errorf("got image %q for %s", dir.ImagePath, dir.Path)
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("walk: %v", err)
+	}
+
+	want := []string{"lib/Alb/01 Track", "lib/Alb/02 Track"}
+	if !slices.Equal(got, want) {
+		t.Errorf("got files %q, want %q", got, want)
+	}
+}
+
+func TestScanFilenameFallback(t *testing.T) {
+	picture := []byte("\x89PNG\r\n\x1a\nart")
+	fsys := fstest.MapFS{
+		"lib/Dir Album/03 - Numbers.mp3": {Data: id3v23(map[string]string{
+			"TRCK": "7/9",
+			"TPOS": "2",
+			"TYER": "1999",
+			"TCON": "Jazz",
+		}, picture)},
+		"lib/Dir Album/04. Untagged.mp3": {Data: mp3Frame},
+		"lib/Dir Album/05 Tagged.mp3": {Data: id3v23(map[string]string{
+			"TIT2": "Title",
+			"TRCK": "1",
+		}, nil)},
+	}
+
+	files, _, err := (&Scanner{FS: fsys}).Scan(context.Background(), []string{"lib"}, nil)
+	if err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	metadata := make(map[string]MediaMetadata)
+	if err := files.WalkFiles(func(dir *PathMeta, file *PathMeta) error {
+		metadata[file.Name] = file.Metadata
+		return nil
+	}); err != nil {
+		t.Fatalf("walk: %v", err)
+	}
+
+	tests := []struct {
+		file  string
+		song  string
+		album string
+		track int
+		disc  int
+		year  int
+		genre string
+		art   bool
+	}{
+		{
+			file:  "03 - Numbers.mp3",
+			song:  "Numbers",
+			album: "Dir Album",
+			track: 7,
+			disc:  2,
+			year:  1999,
+			genre: "Jazz",
+			art:   true,
+		},
+		{file: "04. Untagged.mp3", song: "Untagged", album: "Dir Album", track: 4, genre: unknownGenre},
+		{file: "05 Tagged.mp3", song: "Title", album: unknownAlbum, track: 1, genre: unknownGenre},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.file, func(t *testing.T) {
+			m := metadata[tc.file]
+			if m == nil {
+				t.Fatal("no metadata")
+			}
+			if m.Song() != tc.song || m.Album() != tc.album || m.Track() != tc.track || m.Disc() != tc.disc ||
+				m.Year() != tc.year || m.Genre() != tc.genre || (m.AlbumArtURI() != "") != tc.art {
+				t.Errorf("got %q %q track %d disc %d %d %q art %q", m.Song(), m.Album(), m.Track(), m.Disc(),
+					m.Year(), m.Genre(), m.AlbumArtURI())
+			}
+		})
+	}
+}
+
+// countingFS counts how many times each file is opened.
+type countingFS struct {
+	fs.FS
+	mu     sync.Mutex
+	opened map[string]int
+}
+
+func newCountingFS(fsys fs.FS) *countingFS {
+	return &countingFS{FS: fsys, opened: make(map[string]int)}
+}
+
+func (c *countingFS) Open(name string) (fs.File, error) {
+	c.mu.Lock()
+	c.opened[name]++
+	c.mu.Unlock()
+	return c.FS.Open(name)
+}
+
+func (c *countingFS) reset() {
+	c.mu.Lock()
+	c.opened = make(map[string]int)
+	c.mu.Unlock()
+}
+
+func titledSong(title string) *fstest.MapFile {
+	return &fstest.MapFile{
+		Data: id3v23(map[string]string{
+			"TIT2": title,
+			"TPE1": "Artist",
+			"TALB": "Album",
+		}, nil),
+		ModTime: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+func TestRescan(t *testing.T) {
+	mapFS := fstest.MapFS{
+		"lib/A/1.mp3": titledSong("Same"),
+		"lib/A/2.mp3": titledSong("Touched"),
+		"lib/A/3.mp3": titledSong("Edited"),
+		"lib/A/4.mp3": titledSong("Deleted"),
+	}
+	fsys := newCountingFS(mapFS)
+	scanner := &Scanner{FS: fsys}
+	ctx := context.Background()
+
+	first, report, err := scanner.Scan(ctx, []string{"lib"}, nil)
+	if err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	if want := (ScanReport{Added: 4}); !reportCountsEqual(report, want) {
+		t.Errorf("got first report %+v, want %+v", report, want)
+	}
+
+	mapFS["lib/A/2.mp3"].ModTime = mapFS["lib/A/2.mp3"].ModTime.Add(time.Hour)
+	mapFS["lib/A/3.mp3"] = titledSong("Edited Again")
+	delete(mapFS, "lib/A/4.mp3")
+	mapFS["lib/B/5.mp3"] = titledSong("New")
+	fsys.reset()
+
+	second, report, err := scanner.Scan(ctx, []string{"lib"}, first)
+	if err != nil {
+		t.Fatalf("rescan: %v", err)
+	}
+	if want := (ScanReport{Added: 1, Changed: 2, Removed: 1, Reused: 1}); !reportCountsEqual(report, want) {
+		t.Errorf("got rescan report %+v, want %+v", report, want)
+	}
+
+	wantOpened := map[string]int{"lib/A/2.mp3": 1, "lib/A/3.mp3": 1, "lib/B/5.mp3": 1}
+	for _, name := range []string{"lib/A/1.mp3", "lib/A/2.mp3", "lib/A/3.mp3", "lib/B/5.mp3"} {
+		if got := fsys.opened[name]; got != wantOpened[name] {
+			t.Errorf("%s opened %d times, want %d", name, got, wantOpened[name])
+		}
+	}
+
+	previous := songMetadata(t, first)
+	current := songMetadata(t, second)
+	if current["lib/A/1.mp3"] != previous["lib/A/1.mp3"] {
+		t.Error("unchanged file's metadata not reused")
+	}
+	if got := current["lib/A/3.mp3"].Song(); got != "Edited Again" {
+		t.Errorf("got edited title %q", got)
+	}
+	if _, ok := current["lib/A/4.mp3"]; ok {
+		t.Error("deleted file still scanned")
+	}
+	if got := current["lib/B/5.mp3"]; got == nil || got.Song() != "New" {
+		t.Errorf("got new file metadata %+v", got)
+	}
+}
+
+func reportCountsEqual(got *ScanReport, want ScanReport) bool {
+	return got.Added == want.Added && got.Changed == want.Changed && got.Removed == want.Removed &&
+		got.Reused == want.Reused && len(got.Errors) == 0
+}
+
+func songMetadata(t *testing.T, files *Files) map[string]MediaMetadata {
+	t.Helper()
+	metadata := make(map[string]MediaMetadata)
+	if err := files.WalkFiles(func(dir *PathMeta, file *PathMeta) error {
+		metadata[file.Path] = file.Metadata
+		return nil
+	}); err != nil {
+		t.Fatalf("walk: %v", err)
+	}
+	return metadata
+}
+
+// hookFS calls onOpen before opening each file.
+type hookFS struct {
+	fs.FS
+	onOpen func(name string)
+}
+
+func (h hookFS) Open(name string) (fs.File, error) {
+	h.onOpen(name)
+	return h.FS.Open(name)
+}
+
+func TestScanWorkers(t *testing.T) {
+	fsys := fstest.MapFS{}
+	for album := 0; album < 5; album++ {
+		for track := 0; track < 12; track++ {
+			name := fmt.Sprintf("lib/Artist %d/Album %d/%02d.mp3", album%2, album, track)
+			fsys[name] = titledSong(fmt.Sprintf("Song %d-%d", album, track))
+		}
+	}
+
+	scanOrder := func(workers int) []string {
+		files, _, err := (&Scanner{FS: fsys, Workers: workers}).Scan(context.Background(), []string{"lib"}, nil)
+		if err != nil {
+			t.Fatalf("scan with %d workers: %v", workers, err)
+		}
+		var order []string
+		if err := files.WalkFiles(func(dir *PathMeta, file *PathMeta) error {
+			order = append(order, dir.Path+" "+file.Path+" "+file.Metadata.Song())
+			return nil
+		}); err != nil {
+			t.Fatalf("walk: %v", err)
+		}
+		return order
+	}
+
+	want := scanOrder(1)
+	if len(want) != 60 {
+		t.Fatalf("got %d files, want 60", len(want))
+	}
+	for _, workers := range []int{0, 2, 8, 100} {
+		if got := scanOrder(workers); !slices.Equal(got, want) {
+			t.Errorf("%d workers: got order %q, want %q", workers, got, want)
+		}
+	}
+}
+
+func TestScanCancel(t *testing.T) {
+	mapFS := fstest.MapFS{}
+	for track := 0; track < 20; track++ {
+		mapFS[fmt.Sprintf("lib/Album/%02d.mp3", track)] = titledSong(fmt.Sprintf("Song %d", track))
+	}
+
+	for _, workers := range []int{1, 4} {
+		ctx, cancel := context.WithCancel(context.Background())
+		var mu sync.Mutex
+		opened := 0
+		fsys := hookFS{FS: mapFS, onOpen: func(name string) {
+			if path.Ext(name) != ".mp3" {
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			// cancel once reading tags has started
+			if opened++; opened == 3 {
+				cancel()
+			}
+		}}
+
+		files, _, err := (&Scanner{FS: fsys, Workers: workers}).Scan(ctx, []string{"lib"}, nil)
+		cancel()
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("%d workers: got files %v and error %v, want %v", workers, files, err, context.Canceled)
+		}
+		if opened >= len(mapFS) {
+			t.Errorf("%d workers: read all %d files after cancelling", workers, opened)
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package musiclib
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SearchResults are the artists, albums and tracks matching a search, best
+// match first. URIs are those of the album artist index.
+type SearchResults struct {
+	Artists []*BrowseItem
+	Albums  []*BrowseItem
+	Tracks  []*BrowseItem
+}
+
+// Search returns up to limit artists, albums and tracks containing every
+// word of query in their names or song metadata. A track matches on its
+// title, artist, album artist, album, genre and year. An album matches on
+// its name, its artist and the track artists, genres and years of its songs,
+// and an artist on its name and the genres and years of its songs. Artists
+// only credited on tracks grouped under another album artist, such as those
+// of compilations, are returned with that album artist's URI. Matches of the
+// whole query in an item's own name rank first. A limit less than 1 returns
+// all matches.
+func (l *IndexedLibrary) Search(ctx context.Context, query string, limit int) (*SearchResults, error) {
+	text := foldText(query)
+	words := strings.Fields(text)
+	if len(words) == 0 {
+		return &SearchResults{}, nil
+	}
+
+	roots, err := l.AlbumArtists.Roots(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &librarySearch{
+		text:         text,
+		words:        words,
+		albumArtists: make(map[string]bool, len(roots)),
+		trackArtists: make(map[string]searchTrackArtist),
+	}
+	for _, artist := range roots {
+		s.albumArtists[artist.LowerName] = true
+	}
+	for _, artist := range roots {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		s.searchArtist(artist)
+	}
+	s.searchTrackArtists()
+
+	return &SearchResults{
+		Artists: rankedItems(s.artists, limit),
+		Albums:  rankedItems(s.albums, limit),
+		Tracks:  rankedItems(s.tracks, limit),
+	}, nil
+}
+
+func (r *ReloadableLibrary) Search(ctx context.Context, query string, limit int) (*SearchResults, error) {
+	return r.library().Search(ctx, query, limit)
+}
+
+type searchHit struct {
+	node *Node
+	// name replaces the node's name in the result if set.
+	name string
+	rank int
+}
+
+// searchTrackArtist is an artist credited on a track of albumArtist.
+type searchTrackArtist struct {
+	name        string
+	albumArtist *Node
+}
+
+type librarySearch struct {
+	text  string
+	words []string
+	// albumArtists holds the folded names of the album artists.
+	albumArtists map[string]bool
+	// trackArtists maps the folded names of track artists that differ from
+	// their album artist to where they were first found.
+	trackArtists     map[string]searchTrackArtist
+	trackArtistOrder []string
+
+	artists []searchHit
+	albums  []searchHit
+	tracks  []searchHit
+}
+
+// searchArtist finds the matches among an album artist, its albums and their
+// songs.
+func (s *librarySearch) searchArtist(artist *Node) {
+	artistTerms := newSearchTerms(artist.LowerName)
+	for _, album := range artist.Children {
+		albumTerms := newSearchTerms(album.LowerName, artist.LowerName)
+		for _, song := range album.Children {
+			if song.file == nil || song.file.Metadata == nil {
+				if s.matchAll(song.LowerName, album.LowerName, artist.LowerName) {
+					s.tracks = append(s.tracks, searchHit{
+						node: song,
+						rank: s.rank(song.LowerName, []string{album.LowerName, artist.LowerName}),
+					})
+				}
+				continue
+			}
+
+			folded := song.file.foldedMetadata()
+			var year string
+			if y := song.file.Metadata.Year(); y > 0 {
+				year = strconv.Itoa(y)
+			}
+			trackArtist := folded[queryArtist]
+
+			if s.matchAll(folded[queryTitle], trackArtist, folded[queryAlbumArtist], folded[queryAlbum],
+				folded[queryGenre], year, song.LowerName, album.LowerName, artist.LowerName) {
+				s.tracks = append(s.tracks, searchHit{
+					node: song,
+					rank: s.rank(folded[queryTitle], []string{trackArtist, album.LowerName, artist.LowerName}),
+				})
+			}
+
+			albumTerms.add(trackArtist, folded[queryGenre], year)
+			artistTerms.add(folded[queryGenre], year)
+			if _, ok := s.trackArtists[trackArtist]; !ok && trackArtist != artist.LowerName {
+				s.trackArtists[trackArtist] = searchTrackArtist{
+					name:        song.file.Metadata.Artist(),
+					albumArtist: artist,
+				}
+				s.trackArtistOrder = append(s.trackArtistOrder, trackArtist)
+			}
+		}
+
+		if s.matchAll(albumTerms.values...) {
+			s.albums = append(s.albums, searchHit{
+				node: album,
+				rank: s.rank(album.LowerName, []string{artist.LowerName}),
+			})
+		}
+	}
+
+	if s.matchAll(artistTerms.values...) {
+		s.artists = append(s.artists, searchHit{node: artist, rank: s.rank(artist.LowerName, nil)})
+	}
+}
+
+// searchTrackArtists adds the matching artists that are only credited on
+// tracks of other album artists.
+func (s *librarySearch) searchTrackArtists() {
+	for _, name := range s.trackArtistOrder {
+		if s.albumArtists[name] || !s.matchAll(name) {
+			continue
+		}
+		artist := s.trackArtists[name]
+		s.artists = append(s.artists, searchHit{
+			node: artist.albumArtist,
+			name: artist.name,
+			rank: s.rank(name, nil),
+		})
+	}
+}
+
+// matchAll reports whether every search word is contained in one of values.
+func (s *librarySearch) matchAll(values ...string) bool {
+	for _, word := range s.words {
+		found := false
+		for _, value := range values {
+			if strings.Contains(value, word) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// searchTerms collects the distinct values an album or artist matches on.
+type searchTerms struct {
+	values []string
+	seen   map[string]bool
+}
+
+func newSearchTerms(values ...string) *searchTerms {
+	t := &searchTerms{seen: make(map[string]bool)}
+	t.add(values...)
+	return t
+}
+
+func (t *searchTerms) add(values ...string) {
+	for _, value := range values {
+		if value != "" && !t.seen[value] {
+			t.seen[value] = true
+			t.values = append(t.values, value)
+		}
+	}
+}
+
+// rank rates a match on how well the whole search text matches name, then
+// the related names such as its artist and album. Lower ranks are better.
+func (s *librarySearch) rank(name string, related []string) int {
+	if r := textRank(s.text, name); r >= 0 {
+		return r
+	}
+	best := -1
+	for _, c := range related {
+		if r := textRank(s.text, c); r >= 0 && (best < 0 || r < best) {
+			best = r
+		}
+	}
+	if best >= 0 {
+		return 4 + best
+	}
+	// only the separate words matched
+	return 8
+}
+
+// textRank rates how well text matches name: 0 when equal, 1 as a prefix, 2
+// as a word prefix, 3 anywhere in name and -1 when name doesn't contain it.
+func textRank(text string, name string) int {
+	switch {
+	case name == text:
+		return 0
+	case strings.HasPrefix(name, text):
+		return 1
+	case strings.Contains(name, " "+text):
+		return 2
+	case strings.Contains(name, text):
+		return 3
+	default:
+		return -1
+	}
+}
+
+// rankedItems returns up to limit items in rank order, keeping the index
+// order of equally ranked hits.
+func rankedItems(hits []searchHit, limit int) []*BrowseItem {
+	sort.SliceStable(hits, func(i, j int) bool {
+		return hits[i].rank < hits[j].rank
+	})
+	if limit > 0 && len(hits) > limit {
+		hits = hits[:limit]
+	}
+
+	items := make([]*BrowseItem, 0, len(hits))
+	for _, hit := range hits {
+		item := toBrowseItem(hit.node)
+		if hit.name != "" {
+			item.Name = hit.name
+			item.Stats = NodeStats{}
+		}
+		items = append(items, item)
+	}
+	return items
+}
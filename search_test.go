@@ -0,0 +1,153 @@
+package musiclib
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"testing/fstest"
+)
+
+func TestSearch(t *testing.T) {
+	// id3v23 writes ISO-8859-1 text frames
+	latin1 := func(s string) string {
+		var b []byte
+		for _, r := range s {
+			b = append(b, byte(r))
+		}
+		return string(b)
+	}
+	song := func(artist string, album string, title string, genre string, year string) *fstest.MapFile {
+		return &fstest.MapFile{Data: id3v23(map[string]string{
+			"TPE1": latin1(artist),
+			"TALB": album,
+			"TIT2": latin1(title),
+			"TCON": genre,
+			"TYER": year,
+		}, nil)}
+	}
+	fsys := fstest.MapFS{
+		"lib/Nick Drake/Pink Moon/01.mp3":    song("Nick Drake", "Pink Moon", "Pink Moon", "Folk", "1972"),
+		"lib/Radiohead/OK Computer/01.mp3":   song("Radiohead", "OK Computer", "Airbag", "Rock", "1997"),
+		"lib/Radiohead/OK Computer/02.mp3":   song("Radiohead", "OK Computer", "Paranoid Android", "Rock", "1997"),
+		"lib/Radiohead/Kid A/01.mp3":         song("Radiohead", "Kid A", "Everything in Its Right Place", "Electronic", "2000"),
+		"lib/Mix Tape/01.mp3":                song("Björk", "Mix Tape", "Jóga", "Electronic", "1998"),
+		"lib/Mix Tape/02.mp3":                song("Portishead", "Mix Tape", "Roads", "Electronic", "1998"),
+		"lib/Mix Tape/03.mp3":                song("Massive Attack", "Mix Tape", "Teardrop", "Electronic", "1998"),
+		"lib/Pink Floyd/Meddle/01.mp3":       song("Pink Floyd", "Meddle", "Echoes", "Rock", "1971"),
+		"lib/Pink Floyd/Meddle/cover.jpg":    {Data: []byte("art")},
+		"lib/Nick Drake/Pink Moon/notes.txt": {Data: []byte("notes")},
+	}
+
+	ctx := context.Background()
+	files, _, err := (&Scanner{FS: fsys}).Scan(ctx, []string{"lib"}, nil)
+	if err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	l, err := IndexFiles(ctx, []string{"lib"}, files)
+	if err != nil {
+		t.Fatalf("index: %v", err)
+	}
+
+	variousArtists := encodeCustomURI("artist", DefaultVariousArtists)
+	tests := []struct {
+		query   string
+		artists []string
+		albums  []string
+		tracks  []string
+	}{
+		{query: ""},
+		{query: "   "},
+		{
+			query:   "folk",
+			artists: []string{"Nick Drake"},
+			albums:  []string{"Pink Moon"},
+			tracks:  []string{"Pink Moon"},
+		},
+		{
+			query:   "ROCK",
+			artists: []string{"Pink Floyd", "Radiohead"},
+			albums:  []string{"Meddle", "OK Computer"},
+			tracks:  []string{"Echoes", "Airbag", "Paranoid Android"},
+		},
+		{
+			query:   "radiohead 1997",
+			artists: []string{"Radiohead"},
+			albums:  []string{"OK Computer"},
+			tracks:  []string{"Airbag", "Paranoid Android"},
+		},
+		{
+			query:   "bjork",
+			artists: []string{"Björk"},
+			albums:  []string{"Mix Tape"},
+			tracks:  []string{"Björk - Jóga"},
+		},
+		{
+			query:   "pink",
+			artists: []string{"Pink Floyd"},
+			albums:  []string{"Pink Moon", "Meddle"},
+			tracks:  []string{"Pink Moon", "Echoes"},
+		},
+		{
+			query:   "electronic 1998",
+			artists: []string{"Various Artists"},
+			albums:  []string{"Mix Tape"},
+			tracks:  []string{"Björk - Jóga", "Portishead - Roads", "Massive Attack - Teardrop"},
+		},
+		{query: "jazz"},
+	}
+
+	names := func(items []*BrowseItem) []string {
+		var names []string
+		for _, item := range items {
+			names = append(names, item.Name)
+		}
+		return names
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.query, func(t *testing.T) {
+			results, err := l.Search(ctx, tc.query, 0)
+			if err != nil {
+				t.Fatalf("search: %v", err)
+			}
+			if got := names(results.Artists); !slices.Equal(got, tc.artists) {
+				t.Errorf("got artists %q, want %q", got, tc.artists)
+			}
+			if got := names(results.Albums); !slices.Equal(got, tc.albums) {
+				t.Errorf("got albums %q, want %q", got, tc.albums)
+			}
+			if got := names(results.Tracks); !slices.Equal(got, tc.tracks) {
+				t.Errorf("got tracks %q, want %q", got, tc.tracks)
+			}
+		})
+	}
+
+	t.Run("compilation artist uri", func(t *testing.T) {
+		results, err := l.Search(ctx, "portishead", 0)
+		if err != nil {
+			t.Fatalf("search: %v", err)
+		}
+		if len(results.Artists) != 1 || results.Artists[0].URI != variousArtists {
+			t.Errorf("got artists %+v, want uri %s", results.Artists, variousArtists)
+		}
+	})
+
+	t.Run("limit", func(t *testing.T) {
+		results, err := l.Search(ctx, "rock", 1)
+		if err != nil {
+			t.Fatalf("search: %v", err)
+		}
+		if len(results.Artists) != 1 || len(results.Albums) != 1 || len(results.Tracks) != 1 {
+			t.Errorf("got %d artists, %d albums and %d tracks, want 1 each",
+				len(results.Artists), len(results.Albums), len(results.Tracks))
+		}
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+		if _, err := l.Search(ctx, "rock", 0); err != context.Canceled {
+			t.Errorf("got error %v, want %v", err, context.Canceled)
+		}
+	})
+}
@@ -0,0 +1,55 @@
+package musiclib
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+)
+
+// DefaultArticles are the leading articles ignored when sorting names.
+var DefaultArticles = []string{"The", "A", "An", "Die", "Der", "Das", "Le", "La", "Les", "El", "Los", "Il"}
+
+// stripArticle returns name without a leading article.
+func stripArticle(name string, articles []string) string {
+	for _, article := range articles {
+		n := len(article)
+		if len(name) > n+1 && name[n] == ' ' && strings.EqualFold(name[:n], article) {
+			return strings.TrimSpace(name[n+1:])
+		}
+	}
+	return name
+}
+
+// nameSorter orders nodes by their sort names using Unicode collation. It
+// isn't safe for concurrent use.
+type nameSorter struct {
+	collator *collate.Collator
+	buf      collate.Buffer
+	articles []string
+}
+
+func newNameSorter(articles []string) *nameSorter {
+	return &nameSorter{
+		collator: collate.New(language.Und),
+		articles: articles,
+	}
+}
+
+// sort sets the sort name and key of nodes that don't have them yet and
+// sorts them.
+func (s *nameSorter) sort(nodes []*Node) {
+	for _, node := range nodes {
+		if node.sortKey != nil {
+			continue
+		}
+		if node.SortName == "" {
+			node.SortName = stripArticle(node.Name, s.articles)
+		}
+		node.sortKey = bytes.Clone(s.collator.KeyFromString(&s.buf, node.SortName))
+		s.buf.Reset()
+	}
+	sort.Slice(nodes, nameSort(nodes))
+}
@@ -0,0 +1,60 @@
+package musiclib
+
+import (
+	"time"
+)
+
+// NodeStats summarizes the songs at and below a node.
+type NodeStats struct {
+	// ChildCount is the number of direct children, such as the albums of an
+	// artist.
+	ChildCount int
+	// TrackCount is the number of songs.
+	TrackCount int
+	// Duration is the total duration of the songs with a known duration.
+	Duration time.Duration
+	// Size is the total size of the song files in bytes.
+	Size int64
+	// MinYear and MaxYear are the range of known years. They are 0 when no
+	// song has a year.
+	MinYear int
+	MaxYear int
+	// Modified is the latest modification time of the songs.
+	Modified time.Time
+}
+
+// computeStats sets the stats of n and the nodes below it.
+func computeStats(n *Node) NodeStats {
+	if len(n.Children) == 0 {
+		n.Stats = NodeStats{TrackCount: 1}
+		if n.file != nil && n.file.Metadata != nil {
+			m := n.file.Metadata
+			n.Stats.Duration = m.Duration()
+			n.Stats.Size = n.file.Size
+			n.Stats.MinYear = m.Year()
+			n.Stats.MaxYear = m.Year()
+			n.Stats.Modified = m.Modified()
+		}
+		return n.Stats
+	}
+
+	stats := NodeStats{ChildCount: len(n.Children)}
+	for _, child := range n.Children {
+		stats.add(computeStats(child))
+	}
+	n.Stats = stats
+	return stats
+}
+
+func (s *NodeStats) add(o NodeStats) {
+	s.TrackCount += o.TrackCount
+	s.Duration += o.Duration
+	s.Size += o.Size
+	if o.MinYear != 0 && (s.MinYear == 0 || o.MinYear < s.MinYear) {
+		s.MinYear = o.MinYear
+	}
+	s.MaxYear = max(s.MaxYear, o.MaxYear)
+	if o.Modified.After(s.Modified) {
+		s.Modified = o.Modified
+	}
+}
@@ -0,0 +1,125 @@
+package musiclib
+
+import (
+	"slices"
+	"strings"
+)
+
+type trigram [3]byte
+
+// textIndex finds nodes whose folded names contain some text without walking
+// the node tree. Names are indexed by the byte trigrams they contain once
+// padded with spaces so their first and last letters have trigrams of their
+// own, as trigramSet does for fuzzy matching.
+type textIndex struct {
+	nodes []*Node
+	// trigrams maps each trigram to the ascending positions in nodes of the
+	// names containing it.
+	trigrams map[trigram][]int32
+}
+
+func (t *textIndex) add(n *Node) {
+	if t.trigrams == nil {
+		t.trigrams = make(map[trigram][]int32)
+	}
+	pos := int32(len(t.nodes))
+	t.nodes = append(t.nodes, n)
+
+	name := " " + n.LowerName + " "
+	for i := 0; i+3 <= len(name); i++ {
+		key := trigram{name[i], name[i+1], name[i+2]}
+		postings := t.trigrams[key]
+		// a trigram repeated within a name is only recorded once
+		if len(postings) > 0 && postings[len(postings)-1] == pos {
+			continue
+		}
+		t.trigrams[key] = append(postings, pos)
+	}
+}
+
+// search returns the nodes whose names contain filter along with all of
+// their ancestors. filter must already be folded.
+func (t *textIndex) search(filter string) map[*Node]bool {
+	related := make(map[*Node]bool)
+	for _, pos := range t.candidates(filter) {
+		n := t.nodes[pos]
+		if !strings.Contains(n.LowerName, filter) {
+			continue
+		}
+		for p := n; p != nil && !related[p]; p = p.Parent {
+			related[p] = true
+		}
+	}
+	return related
+}
+
+// similar returns the nodes sharing a trigram with text padded with spaces.
+// Text too short to have a trigram of its own returns every node.
+func (t *textIndex) similar(text string) []*Node {
+	if len(text) < 3 {
+		return t.nodes
+	}
+
+	text = " " + text + " "
+	seen := make(map[int32]bool)
+	var positions []int32
+	for i := 0; i+3 <= len(text); i++ {
+		for _, pos := range t.trigrams[trigram{text[i], text[i+1], text[i+2]}] {
+			if !seen[pos] {
+				seen[pos] = true
+				positions = append(positions, pos)
+			}
+		}
+	}
+	// keep index order so equally similar nodes are returned consistently
+	slices.Sort(positions)
+
+	nodes := make([]*Node, 0, len(positions))
+	for _, pos := range positions {
+		nodes = append(nodes, t.nodes[pos])
+	}
+	return nodes
+}
+
+// candidates returns the positions of nodes that contain every trigram of
+// filter. Filters too short to have a trigram match every node.
+func (t *textIndex) candidates(filter string) []int32 {
+	if len(filter) < 3 {
+		all := make([]int32, len(t.nodes))
+		for i := range all {
+			all[i] = int32(i)
+		}
+		return all
+	}
+
+	var result []int32
+	for i := 0; i+3 <= len(filter); i++ {
+		postings := t.trigrams[trigram{filter[i], filter[i+1], filter[i+2]}]
+		if i == 0 {
+			result = postings
+		} else {
+			result = intersectPostings(result, postings)
+		}
+		if len(result) == 0 {
+			return nil
+		}
+	}
+	return result
+}
+
+func intersectPostings(a []int32, b []int32) []int32 {
+	var result []int32
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			result = append(result, a[i])
+			i++
+			j++
+		}
+	}
+	return result
+}
@@ -0,0 +1,156 @@
+package musiclib
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"testing/fstest"
+)
+
+// walkOnlyIndex hides an index's text search so filters walk subtrees.
+type walkOnlyIndex struct {
+	Index
+}
+
+func TestTextIndexMatchesWalk(t *testing.T) {
+	// id3v23 writes ISO-8859-1 text frames
+	latin1 := func(s string) string {
+		var b []byte
+		for _, r := range s {
+			b = append(b, byte(r))
+		}
+		return string(b)
+	}
+	song := func(artist string, album string, title string, genre string) *fstest.MapFile {
+		return &fstest.MapFile{Data: id3v23(map[string]string{
+			"TPE1": latin1(artist),
+			"TALB": latin1(album),
+			"TIT2": latin1(title),
+			"TCON": genre,
+			"TYER": "1997",
+		}, nil)}
+	}
+	fsys := fstest.MapFS{
+		"lib/Björk/Homogenic/01 Hunter.mp3":         song("Björk", "Homogenic", "Hunter", "Electronic"),
+		"lib/Björk/Homogenic/02 Jóga.mp3":           song("Björk", "Homogenic", "Jóga", "Electronic"),
+		"lib/Nick Drake/Pink Moon/01 Pink Moon.mp3": song("Nick Drake", "Pink Moon", "Pink Moon", "Folk"),
+		"lib/Nick Drake/Pink Moon/02 Place.mp3":     song("Nick Drake", "Pink Moon", "Place To Be", "Folk"),
+		"lib/Sigur Rós/Ágætis byrjun/01.mp3":        song("Sigur Rós", "Ágætis byrjun", "Svefn-g-englar", "Post-Rock"),
+		"lib/Straße/Weiß/01 A.mp3":                  song("Straße", "Weiß", "A", "Rock"),
+		"lib/a/b/c.mp3":                             song("A", "B", "C", "Rock"),
+	}
+
+	ctx := context.Background()
+	files, _, err := (&Scanner{FS: fsys}).Scan(ctx, []string{"lib"}, nil)
+	if err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	l, err := IndexFiles(ctx, []string{"lib"}, files)
+	if err != nil {
+		t.Fatalf("index: %v", err)
+	}
+
+	filters := []string{
+		"",
+		"a",
+		"A",
+		"on",
+		"pink",
+		"PINK MOON",
+		"pink moon",
+		" moon",
+		"moon ",
+		" pink ",
+		"k m",
+		"bjork",
+		"BJÖRK",
+		"ｂｊｏｒｋ",
+		"jóga",
+		"ros",
+		"agætis",
+		"strasse",
+		"weiss",
+		"ß",
+		"é",
+		"svefn-g",
+		"1997",
+		".mp3",
+		"lib/",
+		"xyz",
+	}
+
+	for _, browseType := range []BrowseType{BrowseTypeAlbumArtist, BrowseTypeFile, BrowseTypeGenre, BrowseTypeYear, BrowseTypeModified} {
+		index, err := l.index(browseType)
+		if err != nil {
+			t.Fatalf("index %s: %v", browseType, err)
+		}
+		if _, ok := index.(textSearcher); !ok {
+			t.Fatalf("index %s has no text search", browseType)
+		}
+		walkOnly := walkOnlyIndex{index}
+
+		roots, _ := index.Roots(ctx)
+		var nodes []*Node
+		var addNodes func(children []*Node)
+		addNodes = func(children []*Node) {
+			for _, n := range children {
+				nodes = append(nodes, n)
+				addNodes(n.Children)
+			}
+		}
+		addNodes(roots)
+
+		for _, text := range filters {
+			opts := BrowseOptions{BrowseType: browseType, TextFilter: text}
+
+			indexed, err := newNodeFilter(index, roots, opts)
+			if err != nil {
+				t.Fatalf("filter: %v", err)
+			}
+			walked, err := newNodeFilter(walkOnly, roots, opts)
+			if err != nil {
+				t.Fatalf("filter: %v", err)
+			}
+			if indexed.related == nil && indexed.text != "" {
+				t.Fatalf("%s %q: index not used", browseType, text)
+			}
+			if walked.related != nil {
+				t.Fatalf("%s %q: walk used the index", browseType, text)
+			}
+			if got, want := browseURIs(filter(nil, roots, indexed)), browseURIs(filter(nil, roots, walked)); !slices.Equal(got, want) {
+				t.Errorf("%s %q roots: got %q, want %q", browseType, text, got, want)
+			}
+
+			for _, n := range nodes {
+				indexed, _ := newNodeFilter(index, []*Node{n}, opts)
+				walked, _ := newNodeFilter(walkOnly, []*Node{n}, opts)
+				if got, want := browseURIs(filter(n, n.Children, indexed)), browseURIs(filter(n, n.Children, walked)); !slices.Equal(got, want) {
+					t.Errorf("%s %q browse %s: got %q, want %q", browseType, text, n.URI, got, want)
+				}
+				if got, want := leafURIs(t, index, n, opts), leafURIs(t, walkOnly, n, opts); !slices.Equal(got, want) {
+					t.Errorf("%s %q media %s: got %q, want %q", browseType, text, n.URI, got, want)
+				}
+			}
+		}
+	}
+}
+
+func browseURIs(items []*BrowseItem, err error) []string {
+	var uris []string
+	for _, item := range items {
+		uris = append(uris, item.URI)
+	}
+	return uris
+}
+
+func leafURIs(t *testing.T, index Index, n *Node, opts BrowseOptions) []string {
+	t.Helper()
+	var uris []string
+	if err := walkSelectedLeaves(index, n, opts, nil, func(leaf *Node) error {
+		uris = append(uris, leaf.URI)
+		return nil
+	}); err != nil {
+		t.Fatalf("walk: %v", err)
+	}
+	return uris
+}
@@ -0,0 +1,52 @@
+package musiclib
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrTrackNotFound is returned when a URI doesn't identify a song in the
+// library.
+var ErrTrackNotFound = errors.New("track not found")
+
+// Track is a song file in the library and its metadata.
+type Track struct {
+	URI  string
+	Path string
+	Size int64
+	// ImageURI is the track's art: the image of its directory, art embedded
+	// in its tags or an image inherited from a parent directory, in that
+	// order.
+	ImageURI string
+	// Metadata holds the tags and, when the format supports it, stream
+	// information of the file as indexed. Compilation tracks have the
+	// various artists album artist. It is nil if the file couldn't be read.
+	Metadata MediaMetadata
+}
+
+// Track returns the song identified by a file URI such as those returned by
+// Media.
+func (l *IndexedLibrary) Track(ctx context.Context, uri string) (*Track, error) {
+	for _, index := range []Index{l.AlbumArtists, l.Files} {
+		node, err := index.Node(ctx, uri)
+		if err != nil {
+			return nil, err
+		}
+		if node == nil || node.file == nil || node.IsFolder() {
+			continue
+		}
+		return &Track{
+			URI:      node.URI,
+			Path:     node.file.Path,
+			Size:     node.file.Size,
+			ImageURI: node.ImageURI,
+			Metadata: node.file.Metadata,
+		}, nil
+	}
+
+	return nil, ErrTrackNotFound
+}
+
+func (r *ReloadableLibrary) Track(ctx context.Context, uri string) (*Track, error) {
+	return r.library().Track(ctx, uri)
+}
@@ -0,0 +1,73 @@
+package musiclib
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"testing/fstest"
+
+	"github.com/dhowden/tag"
+)
+
+func TestTrack(t *testing.T) {
+	picture := []byte("\x89PNG\r\n\x1a\ntrack")
+	song := func(album string, picture []byte) *fstest.MapFile {
+		return &fstest.MapFile{Data: id3v23(map[string]string{
+			"TIT2": "Song",
+			"TPE1": "Artist",
+			"TALB": album,
+			"TYER": "2001",
+		}, picture)}
+	}
+	fsys := fstest.MapFS{
+		"lib/cover.jpg":           {Data: []byte("root")},
+		"lib/Folder/cover.jpg":    {Data: []byte("folder")},
+		"lib/Folder/01.mp3":       song("Folder", nil),
+		"lib/Embedded/01.mp3":     song("Embedded", picture),
+		"lib/Inherited/01.mp3":    song("Inherited", nil),
+		"lib/Inherited/notes.txt": {Data: []byte("notes")},
+	}
+
+	ctx := context.Background()
+	files, _, err := (&Scanner{FS: fsys}).Scan(ctx, []string{"lib"}, nil)
+	if err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	l, err := IndexFiles(ctx, []string{"lib"}, files)
+	if err != nil {
+		t.Fatalf("index: %v", err)
+	}
+
+	tests := []struct {
+		path  string
+		image string
+	}{
+		{path: "lib/Folder/01.mp3", image: encodeFileURI("lib/Folder/cover.jpg")},
+		{path: "lib/Embedded/01.mp3", image: embeddedArtURI(&tag.Picture{Data: picture})},
+		{path: "lib/Inherited/01.mp3", image: encodeFileURI("lib/cover.jpg")},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.path, func(t *testing.T) {
+			track, err := l.Track(ctx, encodeFileURI(tc.path))
+			if err != nil {
+				t.Fatalf("track: %v", err)
+			}
+			if track.Path != tc.path || track.Size != int64(len(fsys[tc.path].Data)) {
+				t.Errorf("got path %q size %d", track.Path, track.Size)
+			}
+			if track.ImageURI != tc.image {
+				t.Errorf("got image %q, want %q", track.ImageURI, tc.image)
+			}
+			if track.Metadata == nil || track.Metadata.Song() != "Song" || track.Metadata.Year() != 2001 {
+				t.Errorf("got metadata %+v", track.Metadata)
+			}
+		})
+	}
+
+	for _, uri := range []string{"", encodeFileURI("lib/Folder"), encodeFileURI("lib/Folder/cover.jpg"), "file:missing.mp3"} {
+		if _, err := l.Track(ctx, uri); !errors.Is(err, ErrTrackNotFound) {
+			t.Errorf("Track(%q) got error %v, want %v", uri, err, ErrTrackNotFound)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package musiclib
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// ChangeFunc receives the paths that changed during a debounce window.
+type ChangeFunc func(changedPaths []string)
+
+// WatchRoots watches the directory trees under rootPaths and calls onChange
+// with the changed paths once no further changes have been seen for the
+// debounce duration, or once maxWait has passed since the first of them if
+// changes keep coming. A maxWait of 0 waits for changes to settle however
+// long that takes.
+//
+// onChange runs in its own goroutine so changes keep being read while it
+// runs. Changes seen meanwhile are passed to the next call, which starts
+// once the previous one returns. WatchRoots blocks until ctx is done or
+// watching fails and then waits for a running onChange to return.
+func WatchRoots(ctx context.Context, rootPaths []string, debounce time.Duration, maxWait time.Duration, onChange ChangeFunc) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	changes := make(chan string, 64)
+	watchErr := make(chan error, 1)
+	go func() {
+		watchErr <- watchPaths(ctx, rootPaths, changes)
+	}()
+
+	return debounceChanges(ctx, changes, watchErr, debounce, maxWait, onChange)
+}
+
+// debounceChanges collects the paths received from changes and calls
+// onChange with them as described by WatchRoots.
+func debounceChanges(ctx context.Context, changes <-chan string, watchErr <-chan error, debounce time.Duration, maxWait time.Duration, onChange ChangeFunc) error {
+	pending := make(map[string]struct{})
+	settled := time.NewTimer(debounce)
+	stopTimer(settled)
+	deadline := time.NewTimer(maxWait)
+	stopTimer(deadline)
+	defer settled.Stop()
+	defer deadline.Stop()
+
+	// ready is set once the pending changes settled or, with overdue, once
+	// they waited for maxWait
+	ready := false
+	overdue := false
+	// running is closed when the running onChange returns and nil if none
+	// is running
+	var running chan struct{}
+	wait := func() {
+		if running != nil {
+			<-running
+		}
+	}
+
+	for {
+		select {
+		case changedPath := <-changes:
+			if len(pending) == 0 && maxWait > 0 {
+				deadline.Reset(maxWait)
+			}
+			pending[changedPath] = struct{}{}
+			ready = overdue
+			stopTimer(settled)
+			settled.Reset(debounce)
+		case <-settled.C:
+			ready = true
+		case <-deadline.C:
+			ready = true
+			overdue = true
+		case <-running:
+			running = nil
+		case err := <-watchErr:
+			wait()
+			return err
+		case <-ctx.Done():
+			wait()
+			return ctx.Err()
+		}
+
+		if !ready || running != nil || len(pending) == 0 {
+			continue
+		}
+
+		changedPaths := make([]string, 0, len(pending))
+		for changedPath := range pending {
+			changedPaths = append(changedPaths, changedPath)
+		}
+		sort.Strings(changedPaths)
+		pending = make(map[string]struct{})
+		ready = false
+		overdue = false
+		stopTimer(settled)
+		stopTimer(deadline)
+
+		done := make(chan struct{})
+		running = done
+		go func() {
+			defer close(done)
+			onChange(changedPaths)
+		}()
+	}
+}
+
+// stopTimer stops t and drains a value it already sent so it can be reset.
+func stopTimer(t *time.Timer) {
+	if !t.Stop() {
+		select {
+		case <-t.C:
+		default:
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package musiclib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const watchMask = unix.IN_CREATE | unix.IN_MODIFY | unix.IN_CLOSE_WRITE | unix.IN_ATTRIB |
+	unix.IN_DELETE | unix.IN_DELETE_SELF | unix.IN_MOVED_FROM | unix.IN_MOVED_TO
+
+// watchPaths sends paths changed under rootPaths to changes using inotify
+// until ctx is done.
+func watchPaths(ctx context.Context, rootPaths []string, changes chan<- string) error {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return fmt.Errorf("inotify init: %v", err)
+	}
+	// a non-blocking fd is handled by the runtime poller so closing the file
+	// interrupts a pending read
+	inotify := os.NewFile(uintptr(fd), "inotify")
+
+	w := &inotifyWatcher{
+		fd:      fd,
+		watches: make(map[int]string),
+	}
+	for _, root := range rootPaths {
+		if err := w.addTree(root); err != nil {
+			inotify.Close()
+			return err
+		}
+	}
+
+	go func() {
+		<-ctx.Done()
+		inotify.Close()
+	}()
+
+	buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+	for {
+		n, err := inotify.Read(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("inotify read: %v", err)
+		}
+
+		for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+			event := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameBytes := buf[offset+unix.SizeofInotifyEvent : offset+unix.SizeofInotifyEvent+int(event.Len)]
+			offset += unix.SizeofInotifyEvent + int(event.Len)
+
+			changedPath, err := w.handleEvent(event, nameBytes, rootPaths)
+			if err != nil {
+				return err
+			}
+			if changedPath == "" {
+				continue
+			}
+
+			select {
+			case changes <- changedPath:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+type inotifyWatcher struct {
+	fd      int
+	watches map[int]string
+}
+
+// handleEvent keeps watches in sync with the directory tree and returns the
+// path the event refers to.
+func (w *inotifyWatcher) handleEvent(event *unix.InotifyEvent, nameBytes []byte, rootPaths []string) (string, error) {
+	if event.Mask&unix.IN_Q_OVERFLOW != 0 {
+		// events were dropped so we can't tell what changed
+		return rootPaths[0], nil
+	}
+
+	dir, ok := w.watches[int(event.Wd)]
+	if !ok {
+		return "", nil
+	}
+
+	if event.Mask&unix.IN_IGNORED != 0 {
+		delete(w.watches, int(event.Wd))
+		return "", nil
+	}
+
+	name := string(trimNulls(nameBytes))
+	changedPath := dir
+	if name != "" {
+		changedPath = path.Join(dir, name)
+	}
+
+	if event.Mask&unix.IN_ISDIR != 0 && event.Mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0 {
+		if err := w.addTree(changedPath); err != nil {
+			return "", err
+		}
+	}
+
+	return changedPath, nil
+}
+
+func (w *inotifyWatcher) addTree(root string) error {
+	return filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// the directory may have been removed before we could watch it
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+
+		wd, err := unix.InotifyAddWatch(w.fd, p, watchMask)
+		if err != nil {
+			if errors.Is(err, unix.ENOENT) {
+				return nil
+			}
+			return fmt.Errorf("watch %s: %v", p, err)
+		}
+		w.watches[wd] = p
+
+		return nil
+	})
+}
+
+func trimNulls(b []byte) []byte {
+	for len(b) > 0 && b[len(b)-1] == 0 {
+		b = b[:len(b)-1]
+	}
+	return b
+}
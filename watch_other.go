@@ -0,0 +1,12 @@
+//go:build !linux
+
+package musiclib
+
+import (
+	"context"
+	"errors"
+)
+
+func watchPaths(ctx context.Context, rootPaths []string, changes chan<- string) error {
+	return errors.New("filesystem watching is not supported on this platform")
+}
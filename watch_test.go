@@ -0,0 +1,141 @@
+package musiclib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+type debounceTest struct {
+	changes chan string
+	calls   chan []string
+	result  chan error
+	cancel  context.CancelFunc
+}
+
+// startDebounce runs debounceChanges with an onChange that reports each
+// call and then waits for release if it isn't nil.
+func startDebounce(debounce time.Duration, maxWait time.Duration, release <-chan struct{}) *debounceTest {
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &debounceTest{
+		changes: make(chan string),
+		calls:   make(chan []string, 10),
+		result:  make(chan error, 1),
+		cancel:  cancel,
+	}
+	go func() {
+		d.result <- debounceChanges(ctx, d.changes, nil, debounce, maxWait, func(changedPaths []string) {
+			d.calls <- changedPaths
+			if release != nil {
+				<-release
+			}
+		})
+	}()
+	return d
+}
+
+func (d *debounceTest) send(t *testing.T, changedPath string) {
+	t.Helper()
+	select {
+	case d.changes <- changedPath:
+	case <-time.After(time.Second):
+		t.Fatalf("sending %s blocked", changedPath)
+	}
+}
+
+func (d *debounceTest) nextCall(t *testing.T) []string {
+	t.Helper()
+	select {
+	case changedPaths := <-d.calls:
+		return changedPaths
+	case <-time.After(2 * time.Second):
+		t.Fatal("onChange not called")
+		return nil
+	}
+}
+
+func (d *debounceTest) stop(t *testing.T) {
+	t.Helper()
+	d.cancel()
+	if err := <-d.result; !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestDebounceChanges(t *testing.T) {
+	d := startDebounce(20*time.Millisecond, 0, nil)
+	d.send(t, "b")
+	d.send(t, "a")
+	d.send(t, "b")
+	if got, want := d.nextCall(t), []string{"a", "b"}; !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	d.send(t, "c")
+	if got, want := d.nextCall(t), []string{"c"}; !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	d.stop(t)
+}
+
+func TestDebounceChangesMaxWait(t *testing.T) {
+	d := startDebounce(50*time.Millisecond, 100*time.Millisecond, nil)
+	start := time.Now()
+	// changes that never settle still trigger a call after maxWait
+	for i := 0; len(d.calls) == 0; i++ {
+		if time.Since(start) > time.Second {
+			t.Fatal("onChange not called while changes kept coming")
+		}
+		d.send(t, fmt.Sprint(i))
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := d.nextCall(t); len(got) == 0 {
+		t.Error("got no changed paths")
+	}
+	d.stop(t)
+}
+
+func TestDebounceChangesDuringCall(t *testing.T) {
+	release := make(chan struct{})
+	d := startDebounce(10*time.Millisecond, 0, release)
+	d.send(t, "first")
+	if got, want := d.nextCall(t), []string{"first"}; !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// changes are still read while onChange runs and are coalesced into one
+	// call once it returns
+	var want []string
+	for i := 0; i < 200; i++ {
+		changedPath := fmt.Sprintf("%03d", i)
+		d.send(t, changedPath)
+		want = append(want, changedPath)
+	}
+	time.Sleep(30 * time.Millisecond)
+	select {
+	case got := <-d.calls:
+		t.Fatalf("onChange called with %q while running", got)
+	default:
+	}
+	release <- struct{}{}
+
+	if got := d.nextCall(t); !slices.Equal(got, want) {
+		t.Errorf("got %d changes, want %d", len(got), len(want))
+	}
+
+	// cancelling waits for the running onChange to return
+	stopped := make(chan struct{})
+	go func() {
+		d.stop(t)
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		t.Fatal("stopped while onChange was running")
+	case <-time.After(20 * time.Millisecond):
+	}
+	release <- struct{}{}
+	<-stopped
+}